@@ -14,44 +14,156 @@
 
 package kvbench
 
-import (
-	"strings"
-)
-
 type ResultStatus int
 
 const (
-	ResultOK  ResultStatus = 1
-	ResultERR ResultStatus = 2
+	ResultOK        ResultStatus = 1
+	ResultERR       ResultStatus = 2 // any failure not covered by the other classes
+	ResultTimeout   ResultStatus = 3
+	ResultNotFound  ResultStatus = 4
+	ResultConflict  ResultStatus = 5
+	ResultThrottled ResultStatus = 6
+	ResultConnErr   ResultStatus = 7
+
+	resultStatusCap = 8
+)
+
+var (
+	resultStatusNameMap = map[ResultStatus]string{
+		ResultOK:        "ok",
+		ResultERR:       "other",
+		ResultTimeout:   "timeout",
+		ResultNotFound:  "not-found",
+		ResultConflict:  "conflict",
+		ResultThrottled: "throttled",
+		ResultConnErr:   "connection-error",
+	}
 )
 
 const (
-	BenchTypeRandWrite uint64 = 1 << 0
-	BenchTypeRandRead  uint64 = 1 << 1
-	BenchTypeSeqWrite  uint64 = 1 << 2
-	BenchTypeSeqRead   uint64 = 1 << 3
+	BenchTypeRandWrite  uint64 = 1 << 0
+	BenchTypeRandRead   uint64 = 1 << 1
+	BenchTypeSeqWrite   uint64 = 1 << 2
+	BenchTypeSeqRead    uint64 = 1 << 3
+	BenchTypeMixed      uint64 = 1 << 4
+	BenchTypeYcsbA      uint64 = 1 << 5
+	BenchTypeYcsbB      uint64 = 1 << 6
+	BenchTypeYcsbC      uint64 = 1 << 7
+	BenchTypeYcsbD      uint64 = 1 << 8
+	BenchTypeYcsbE      uint64 = 1 << 9
+	BenchTypeYcsbF      uint64 = 1 << 10
+	BenchTypeRandDelete uint64 = 1 << 11
+	BenchTypeSeqDelete  uint64 = 1 << 12
+	BenchTypeRandScan   uint64 = 1 << 13
+	BenchTypeSeqScan    uint64 = 1 << 14
+	BenchTypeTrace      uint64 = 1 << 15
 
-	BenchTypeNameRandWrite = "rand-write"
-	BenchTypeNameRandRead  = "rand-read"
-	BenchTypeNameSeqWrite  = "seq-write"
-	BenchTypeNameSeqRead   = "seq-read"
+	BenchTypeNameRandWrite  = "rand-write"
+	BenchTypeNameRandRead   = "rand-read"
+	BenchTypeNameSeqWrite   = "seq-write"
+	BenchTypeNameSeqRead    = "seq-read"
+	BenchTypeNameMixed      = "mixed"
+	BenchTypeNameYcsbA      = "ycsb-a"
+	BenchTypeNameYcsbB      = "ycsb-b"
+	BenchTypeNameYcsbC      = "ycsb-c"
+	BenchTypeNameYcsbD      = "ycsb-d"
+	BenchTypeNameYcsbE      = "ycsb-e"
+	BenchTypeNameYcsbF      = "ycsb-f"
+	BenchTypeNameRandDelete = "rand-delete"
+	BenchTypeNameSeqDelete  = "seq-delete"
+	BenchTypeNameRandScan   = "rand-scan"
+	BenchTypeNameSeqScan    = "seq-scan"
+	BenchTypeNameTrace      = "trace"
 )
 
 var (
 	benchTypeMap = map[string]uint64{
-		BenchTypeNameRandWrite: BenchTypeRandWrite,
-		BenchTypeNameRandRead:  BenchTypeRandRead,
-		BenchTypeNameSeqWrite:  BenchTypeSeqWrite,
-		BenchTypeNameSeqRead:   BenchTypeSeqRead,
+		BenchTypeNameRandWrite:  BenchTypeRandWrite,
+		BenchTypeNameRandRead:   BenchTypeRandRead,
+		BenchTypeNameSeqWrite:   BenchTypeSeqWrite,
+		BenchTypeNameSeqRead:    BenchTypeSeqRead,
+		BenchTypeNameMixed:      BenchTypeMixed,
+		BenchTypeNameYcsbA:      BenchTypeYcsbA,
+		BenchTypeNameYcsbB:      BenchTypeYcsbB,
+		BenchTypeNameYcsbC:      BenchTypeYcsbC,
+		BenchTypeNameYcsbD:      BenchTypeYcsbD,
+		BenchTypeNameYcsbE:      BenchTypeYcsbE,
+		BenchTypeNameYcsbF:      BenchTypeYcsbF,
+		BenchTypeNameRandDelete: BenchTypeRandDelete,
+		BenchTypeNameSeqDelete:  BenchTypeSeqDelete,
+		BenchTypeNameRandScan:   BenchTypeRandScan,
+		BenchTypeNameSeqScan:    BenchTypeSeqScan,
+		BenchTypeNameTrace:      BenchTypeTrace,
 	}
 	benchTypeNameMap = map[uint64]string{
-		BenchTypeRandWrite: BenchTypeNameRandWrite,
-		BenchTypeRandRead:  BenchTypeNameRandRead,
-		BenchTypeSeqWrite:  BenchTypeNameSeqWrite,
-		BenchTypeSeqRead:   BenchTypeNameSeqRead,
+		BenchTypeRandWrite:  BenchTypeNameRandWrite,
+		BenchTypeRandRead:   BenchTypeNameRandRead,
+		BenchTypeSeqWrite:   BenchTypeNameSeqWrite,
+		BenchTypeSeqRead:    BenchTypeNameSeqRead,
+		BenchTypeMixed:      BenchTypeNameMixed,
+		BenchTypeYcsbA:      BenchTypeNameYcsbA,
+		BenchTypeYcsbB:      BenchTypeNameYcsbB,
+		BenchTypeYcsbC:      BenchTypeNameYcsbC,
+		BenchTypeYcsbD:      BenchTypeNameYcsbD,
+		BenchTypeYcsbE:      BenchTypeNameYcsbE,
+		BenchTypeYcsbF:      BenchTypeNameYcsbF,
+		BenchTypeRandDelete: BenchTypeNameRandDelete,
+		BenchTypeSeqDelete:  BenchTypeNameSeqDelete,
+		BenchTypeRandScan:   BenchTypeNameRandScan,
+		BenchTypeSeqScan:    BenchTypeNameSeqScan,
+		BenchTypeTrace:      BenchTypeNameTrace,
+	}
+
+	// YCSB core workloads, see
+	// https://github.com/brianfrankcooper/YCSB/wiki/Core-Workloads
+	benchPresetMap = map[uint64]*benchPreset{
+		// update heavy
+		BenchTypeYcsbA: {
+			opRatio: "read:50,write:50",
+			keyDist: keyDistNameZipfian,
+		},
+		// read mostly
+		BenchTypeYcsbB: {
+			opRatio: "read:95,write:5",
+			keyDist: keyDistNameZipfian,
+		},
+		// read only
+		BenchTypeYcsbC: {
+			opRatio: "read:100",
+			keyDist: keyDistNameZipfian,
+		},
+		// read latest
+		BenchTypeYcsbD: {
+			opRatio: "read:95,insert:5",
+			keyDist: keyDistNameLatest,
+		},
+		// short ranges
+		BenchTypeYcsbE: {
+			opRatio:    "scan:95,insert:5",
+			keyDist:    keyDistNameZipfian,
+			scanLenMax: 100,
+		},
+		// read-modify-write
+		BenchTypeYcsbF: {
+			opRatio: "read:50,read-modify-write:50",
+			keyDist: keyDistNameZipfian,
+		},
 	}
 )
 
+type benchPreset struct {
+	opRatio    string
+	keyDist    string
+	scanLenMax int
+}
+
+func resultStatusName(v ResultStatus) string {
+	if s, ok := resultStatusNameMap[v]; ok {
+		return s
+	}
+	return resultStatusNameMap[ResultERR]
+}
+
 func uint64Allow(base, diff uint64) bool {
 	return (diff & base) == diff
 }
@@ -63,25 +175,16 @@ func benchTypeName(t uint64) string {
 	return ""
 }
 
+func benchTypePreset(t uint64) *benchPreset {
+	if p, ok := benchPresetMap[t]; ok {
+		return p
+	}
+	return nil
+}
+
 func benchType(name string) uint64 {
 	if v, ok := benchTypeMap[name]; ok {
 		return v
 	}
 	return 0
 }
-
-func benchTypes(s string) []uint64 {
-	var (
-		ar = strings.Split(s, ",")
-		ta = uint64(0)
-		ts = []uint64{}
-	)
-	for _, v := range ar {
-		v2 := benchType(v)
-		if v2 > 0 && !uint64Allow(ta, v2) {
-			ts = append(ts, v2)
-			ta = ta | v2
-		}
-	}
-	return ts
-}
@@ -36,6 +36,7 @@ type chartOptions struct {
 	dataAttrFilter        []string
 	chartThroughputEnable bool
 	chartLatencyEnable    bool
+	chartPercentileEnable bool
 }
 
 func matExp(ar0, ar1 [][]string) [][]string {
@@ -66,76 +67,127 @@ func matExp(ar0, ar1 [][]string) [][]string {
 	return ar0
 }
 
-func newchartOptions() (*chartOptions, error) {
+// ChartOptions holds the settings of ChartOutput, one field per command line
+// flag of the same name. DataName and DataAttrGroup hold one or two lists
+// of up to four alternatives, like "a,b#c" of the flags; every combination
+// of the alternatives is charted.
+type ChartOptions struct {
+	DataFiles                   []string
+	ChartTitle                  string
+	ChartName                   string
+	DataName                    [][]string
+	DataAttrGroup               [][]string
+	DataAttrFilter              []string
+	DataThroughputEnable        bool
+	DataLatencyEnable           bool
+	DataLatencyPercentileEnable bool
+}
 
-	it := &chartOptions{
-		dataFiles: []string{"lynkbench.json"},
-		chartName: "lynkbench",
+// DefaultChartOptions returns the options used when no flag is set.
+func DefaultChartOptions() *ChartOptions {
+	return &ChartOptions{
+		DataFiles: []string{"lynkbench.json"},
+		ChartName: "lynkbench",
 	}
+}
 
-	if v, ok := hflag.ValueOK("data_file"); ok {
-		it.dataFiles = strings.Split(v.String(), ",")
-	}
+// chartFlagOptions returns the default options overridden by the command
+// line flags.
+func chartFlagOptions() *ChartOptions {
 
-	if v, ok := hflag.ValueOK("chart_title"); ok {
-		it.chartTitle = v.String()
-	}
+	o := DefaultChartOptions()
 
-	if v, ok := hflag.ValueOK("chart_name"); ok {
-		it.chartName = v.String()
+	if v, ok := hflag.ValueOK("data_file"); ok {
+		o.DataFiles = strings.Split(v.String(), ",")
 	}
 
+	flagString("chart_title", &o.ChartTitle)
+	flagString("chart_name", &o.ChartName)
+
 	if v, ok := hflag.ValueOK("data_name"); ok {
-		vArr := strings.Split(v.String(), "#")
-		if n := len(vArr); n < 1 || n > 2 {
-			return nil, errors.New("invalid --data_name")
-		}
-		it.dataName = [][]string{}
-		for _, v2 := range vArr {
-			v3 := strings.Split(v2, ",")
-			if len(v3) < 1 || len(v3) > 4 {
-				return nil, errors.New("invalid --data_name")
-			}
-			it.dataName = append(it.dataName, v3)
+		for _, v2 := range strings.Split(v.String(), "#") {
+			o.DataName = append(o.DataName, strings.Split(v2, ","))
 		}
-		it.dataName = matExp(nil, it.dataName)
 	}
 
 	if v, ok := hflag.ValueOK("data_attr_filter"); ok {
-		it.dataAttrFilter = strings.Split(v.String(), ",")
+		o.DataAttrFilter = strings.Split(v.String(), ",")
 	}
 
 	if v, ok := hflag.ValueOK("data_attr_group"); ok {
-		vArr := strings.Split(v.String(), "#")
-		if n := len(vArr); n < 1 || n > 2 {
-			return nil, errors.New("invalid --data_attr_group")
+		for _, v2 := range strings.Split(v.String(), "#") {
+			o.DataAttrGroup = append(o.DataAttrGroup, strings.Split(v2, ","))
 		}
-		it.dataAttrGroup = [][]string{}
-		for _, v2 := range vArr {
-			v3 := strings.Split(v2, ",")
-			if len(v3) < 1 || len(v3) > 4 {
-				return nil, errors.New("invalid --data_attr_group")
-			}
-			it.dataAttrGroup = append(it.dataAttrGroup, v3)
+	}
+
+	flagBool("data_throughput_enable", &o.DataThroughputEnable)
+	flagBool("data_latency_enable", &o.DataLatencyEnable)
+	flagBool("data_latency_percentile_enable", &o.DataLatencyPercentileEnable)
+
+	return o
+}
+
+// chartMatrix checks the one or two lists of up to four alternatives of a
+// matrix option and expands them to their combinations.
+func chartMatrix(ar [][]string) ([][]string, bool) {
+
+	if n := len(ar); n < 1 || n > 2 {
+		return nil, false
+	}
+
+	for _, v := range ar {
+		if len(v) < 1 || len(v) > 4 {
+			return nil, false
 		}
+	}
+
+	return matExp(nil, ar), true
+}
 
-		it.dataAttrGroup = matExp(nil, it.dataAttrGroup)
+func newchartOptions(o *ChartOptions) (*chartOptions, error) {
+
+	it := &chartOptions{
+		dataFiles:             append([]string{}, o.DataFiles...),
+		chartTitle:            o.ChartTitle,
+		chartName:             o.ChartName,
+		dataAttrFilter:        append([]string{}, o.DataAttrFilter...),
+		chartThroughputEnable: o.DataThroughputEnable,
+		chartLatencyEnable:    o.DataLatencyEnable,
+		chartPercentileEnable: o.DataLatencyPercentileEnable,
+	}
+
+	if len(it.dataFiles) < 1 {
+		return nil, errors.New("no --data_file found")
 	}
 
-	if _, ok := hflag.ValueOK("data_throughput_enable"); ok {
-		it.chartThroughputEnable = true
+	if o.DataName != nil {
+		ar, ok := chartMatrix(o.DataName)
+		if !ok {
+			return nil, errors.New("invalid --data_name")
+		}
+		it.dataName = ar
 	}
 
-	if _, ok := hflag.ValueOK("data_latency_enable"); ok {
-		it.chartLatencyEnable = true
+	if o.DataAttrGroup != nil {
+		ar, ok := chartMatrix(o.DataAttrGroup)
+		if !ok {
+			return nil, errors.New("invalid --data_attr_group")
+		}
+		it.dataAttrGroup = ar
 	}
 
 	return it, nil
 }
 
+// ChartOutput renders the charts configured by the command line flags.
 func ChartOutput() error {
+	return ChartOutputWithOptions(chartFlagOptions())
+}
+
+// ChartOutputWithOptions renders the charts configured by o.
+func ChartOutputWithOptions(o *ChartOptions) error {
 
-	opts, err := newchartOptions()
+	opts, err := newchartOptions(o)
 	if err != nil {
 		return err
 	}
@@ -161,6 +213,10 @@ func ChartOutput() error {
 		fmt.Println(err)
 	}
 
+	if err = chartLatencyPercentileGroup(opts, ls); err != nil {
+		fmt.Println(err)
+	}
+
 	return nil
 }
 
@@ -371,3 +427,118 @@ func chartLatencyAvgGroup(opts *chartOptions, ls hcapi.DataList) error {
 		SvgEnable: true,
 	})
 }
+
+func chartLatencyPercentileGroup(opts *chartOptions, ls hcapi.DataList) error {
+
+	if !opts.chartPercentileEnable {
+		return nil
+	}
+
+	if len(opts.dataAttrGroup) < 1 {
+		return errors.New("no --data_attr_group found")
+	}
+
+	var (
+		item = hcapi.ChartItem{
+			Type: hcapi.ChartTypeBar,
+		}
+		sets = hcapi.DataList{}
+	)
+
+	if opts.chartTitle != "" {
+		item.Options.Title = opts.chartTitle + " "
+	}
+	item.Options.Title += "Latency Percentiles"
+	item.Options.Y.Title = "Latency (us)"
+
+	for _, ds := range ls.Items {
+
+		if !types.ArrayStringHas(ds.Attrs, "latency-percentiles") {
+			continue
+		}
+
+		dnh := false
+		for _, dnv := range opts.dataName {
+			if types.ArrayStringHit(ds.Attrs, dnv) == len(dnv) {
+				dnh = true
+				break
+			}
+		}
+		if !dnh {
+			continue
+		}
+
+		if len(opts.dataAttrFilter) > 0 &&
+			types.ArrayStringHit(ds.Attrs, opts.dataAttrFilter) != len(opts.dataAttrFilter) {
+			continue
+		}
+
+		bgh := false
+		for _, bgv := range opts.dataAttrGroup {
+			if types.ArrayStringHit(ds.Attrs, bgv) == len(bgv) {
+				bgh = true
+				break
+			}
+		}
+		if !bgh {
+			continue
+		}
+
+		ds.AttrSet(ds.Name)
+
+		sets.Set(ds)
+	}
+
+	for _, p := range latencyPercentiles {
+		if p >= 100 {
+			item.Labels = append(item.Labels, "max")
+		} else {
+			item.Labels = append(item.Labels, fmt.Sprintf("p%g", p))
+		}
+	}
+
+	// one dataset per data name and attr group, one point per percentile
+	item.Datasets = []*hcapi.DataItem{}
+	for _, g := range opts.dataName {
+		for _, cg := range opts.dataAttrGroup {
+			gds := hcapi.NewDataItem(strings.Join(g, "/") + " " + strings.Join(cg, "/"))
+			for i := 0; i < len(latencyPercentiles); i++ {
+				gds.Points = append(gds.Points, &hcapi.DataPoint{
+					Y: 0.0,
+				})
+			}
+			item.Datasets = append(item.Datasets, gds)
+		}
+	}
+
+	for _, ds := range sets.Items {
+
+		for gi, g := range opts.dataName {
+
+			if types.ArrayStringHit(ds.Attrs, g) != len(g) {
+				continue
+			}
+
+			for cgi, cg := range opts.dataAttrGroup {
+
+				if types.ArrayStringHit(ds.Attrs, cg) != len(cg) {
+					continue
+				}
+
+				gds := item.Datasets[gi*len(opts.dataAttrGroup)+cgi]
+				for _, p := range ds.Points {
+					for pi, pv := range latencyPercentiles {
+						if p.X == pv {
+							gds.Points[pi].Y = p.Y
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return hcutil.Render(&item, &hcapi.ChartRenderOptions{
+		Name:      opts.chartName + "_latency_percentile",
+		SvgEnable: true,
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	histSubBits  = 7
+	histSubCount = 1 << histSubBits
+	histMaxBits  = 36 // values up to 2^36 us, about 19 hours
+	histCap      = (histMaxBits-histSubBits-1)*histSubCount + 2*histSubCount
+	histMax      = int64(1)<<histMaxBits - 1
+)
+
+var (
+	latencyPercentiles = []float64{50, 75, 90, 99, 99.9, 99.99, 100}
+)
+
+// latencyHistogram is a high dynamic range histogram of latencies in
+// microseconds. Each power of two range is split into histSubCount linear
+// buckets, so a recorded value is kept within 1/histSubCount of its real
+// value, from 1 us to histMax.
+type latencyHistogram struct {
+	counts [histCap]int64
+	total  int64
+	min    int64
+	max    int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		min: math.MaxInt64,
+	}
+}
+
+func histIndex(v int64) int {
+	if v < histSubCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 1 - histSubBits
+	return shift*histSubCount + int(v>>uint(shift))
+}
+
+// histValue returns the highest value recorded in the bucket at index i.
+func histValue(i int) int64 {
+	if i < histSubCount {
+		return int64(i)
+	}
+	shift := i/histSubCount - 1
+	return int64((i-shift*histSubCount)+1)<<uint(shift) - 1
+}
+
+func (it *latencyHistogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	} else if v > histMax {
+		v = histMax
+	}
+	it.counts[histIndex(v)] += 1
+	it.total += 1
+	if v < it.min {
+		it.min = v
+	}
+	if v > it.max {
+		it.max = v
+	}
+}
+
+// recordAtomic is record for a histogram shared by concurrent goroutines.
+func (it *latencyHistogram) recordAtomic(v int64) {
+	if v < 0 {
+		v = 0
+	} else if v > histMax {
+		v = histMax
+	}
+	atomic.AddInt64(&it.counts[histIndex(v)], 1)
+	atomic.AddInt64(&it.total, 1)
+	for m := atomic.LoadInt64(&it.min); v < m; m = atomic.LoadInt64(&it.min) {
+		if atomic.CompareAndSwapInt64(&it.min, m, v) {
+			break
+		}
+	}
+	for m := atomic.LoadInt64(&it.max); v > m; m = atomic.LoadInt64(&it.max) {
+		if atomic.CompareAndSwapInt64(&it.max, m, v) {
+			break
+		}
+	}
+}
+
+// mergeAtomic is merge of a histogram recorded by recordAtomic. The total
+// is counted from the buckets, so it matches them while h is being updated.
+func (it *latencyHistogram) mergeAtomic(h *latencyHistogram) {
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n > 0 {
+			it.counts[i] += n
+			it.total += n
+		}
+	}
+	if m := atomic.LoadInt64(&h.min); m < it.min {
+		it.min = m
+	}
+	if m := atomic.LoadInt64(&h.max); m > it.max {
+		it.max = m
+	}
+}
+
+func (it *latencyHistogram) merge(h *latencyHistogram) {
+	for i, n := range h.counts {
+		it.counts[i] += n
+	}
+	it.total += h.total
+	if h.min < it.min {
+		it.min = h.min
+	}
+	if h.max > it.max {
+		it.max = h.max
+	}
+}
+
+// corrected returns a copy of the histogram corrected for coordinated
+// omission: every value larger than the expected interval between requests
+// also accounts for the requests that would have been issued while it was
+// outstanding, at value - interval, value - 2*interval, and so on.
+func (it *latencyHistogram) corrected(interval int64) *latencyHistogram {
+
+	hc := newLatencyHistogram()
+	hc.merge(it)
+
+	if interval < 1 {
+		return hc
+	}
+
+	for i, n := range it.counts {
+
+		if n == 0 {
+			continue
+		}
+
+		v := histValue(i)
+		if v > it.max {
+			v = it.max
+		}
+
+		for v2 := v - interval; v2 >= interval; v2 -= interval {
+			hc.counts[histIndex(v2)] += n
+			hc.total += n
+		}
+	}
+
+	return hc
+}
+
+// delta returns the values recorded since the histogram was prev. Its min
+// and max are known to the precision of a bucket only.
+func (it *latencyHistogram) delta(prev *latencyHistogram) *latencyHistogram {
+
+	d := newLatencyHistogram()
+
+	for i, n := range it.counts {
+
+		if n -= prev.counts[i]; n < 1 {
+			continue
+		}
+
+		d.counts[i] = n
+		d.total += n
+
+		if d.min == math.MaxInt64 {
+			if d.min = 0; i > 0 {
+				d.min = histValue(i-1) + 1
+			}
+			if d.min < it.min {
+				d.min = it.min
+			}
+		}
+		if d.max = histValue(i); d.max > it.max {
+			d.max = it.max
+		}
+	}
+
+	return d
+}
+
+func (it *latencyHistogram) reset() {
+	*it = latencyHistogram{
+		min: math.MaxInt64,
+	}
+}
+
+// percentile returns the latency at or below which p percent of the
+// recorded values fall.
+func (it *latencyHistogram) percentile(p float64) int64 {
+
+	if it.total < 1 {
+		return 0
+	}
+
+	if p >= 100 {
+		return it.max
+	}
+
+	var (
+		target = int64(math.Ceil(p / 100 * float64(it.total)))
+		num    = int64(0)
+	)
+	if target < 1 {
+		target = 1
+	}
+
+	for i, n := range it.counts {
+		if num += n; num >= target {
+			if v := histValue(i); v < it.max {
+				return v
+			}
+			return it.max
+		}
+	}
+
+	return it.max
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"testing"
+)
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+
+	for _, v := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 12345, 1e6, histMax} {
+
+		i := histIndex(v)
+		if i < 0 || i >= histCap {
+			t.Fatalf("value %d: index %d out of [0, %d)", v, i, histCap)
+		}
+
+		// v is in the bucket i, within 1/histSubCount of its highest value
+		hi := histValue(i)
+		if hi < v || (i > 0 && histValue(i-1) >= v) {
+			t.Fatalf("value %d: bucket %d holds (%d, %d]", v, i, histValue(i-1), hi)
+		}
+		if hi-v > v/histSubCount {
+			t.Fatalf("value %d: bucket %d highest value %d too far", v, i, hi)
+		}
+	}
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+
+	h := newLatencyHistogram()
+	if v := h.percentile(50); v != 0 {
+		t.Fatalf("empty p50 %d, want 0", v)
+	}
+
+	// 1 to 100, exact below histSubCount
+	for v := int64(1); v <= 100; v++ {
+		h.record(v)
+	}
+
+	for _, v := range []struct {
+		p   float64
+		val int64
+	}{
+		{0, 1},
+		{1, 1},
+		{50, 50},
+		{99, 99},
+		{99.9, 100},
+		{100, 100},
+	} {
+		if val := h.percentile(v.p); val != v.val {
+			t.Fatalf("p%g %d, want %d", v.p, val, v.val)
+		}
+	}
+
+	h.record(-5)
+	h.record(histMax + 1)
+	if h.min != 0 || h.max != histMax {
+		t.Fatalf("min %d max %d, want 0 %d", h.min, h.max, histMax)
+	}
+}
+
+func TestLatencyHistogramDelta(t *testing.T) {
+
+	h := newLatencyHistogram()
+	for v := int64(1); v <= 10; v++ {
+		h.record(v)
+	}
+
+	prev := newLatencyHistogram()
+	prev.merge(h)
+
+	for _, v := range []int64{20, 30, 1000} {
+		h.record(v)
+	}
+
+	d := h.delta(prev)
+	if d.total != 3 {
+		t.Fatalf("delta total %d, want 3", d.total)
+	}
+	if d.min != 20 || d.max != 1000 {
+		t.Fatalf("delta min %d max %d, want 20 1000", d.min, d.max)
+	}
+	if v := d.percentile(50); v != 30 {
+		t.Fatalf("delta p50 %d, want 30", v)
+	}
+
+	if d := h.delta(h); d.total != 0 || d.percentile(99) != 0 {
+		t.Fatalf("empty delta total %d", d.total)
+	}
+}
+
+func TestLatencyHistogramCorrected(t *testing.T) {
+
+	h := newLatencyHistogram()
+	h.record(10)
+	h.record(100)
+
+	if hc := h.corrected(0); hc.total != 2 {
+		t.Fatalf("corrected(0) total %d, want 2", hc.total)
+	}
+
+	// 100 at an interval of 25 also accounts for 75, 50 and 25
+	hc := h.corrected(25)
+	if hc.total != 5 {
+		t.Fatalf("corrected(25) total %d, want 5", hc.total)
+	}
+	for _, v := range []struct {
+		p   float64
+		val int64
+	}{
+		{20, 10},
+		{40, 25},
+		{60, 50},
+		{80, 75},
+		{100, 100},
+	} {
+		if val := hc.percentile(v.p); val != v.val {
+			t.Fatalf("corrected p%g %d, want %d", v.p, val, v.val)
+		}
+	}
+
+	// the source is not changed
+	if h.total != 2 {
+		t.Fatalf("total %d after corrected, want 2", h.total)
+	}
+}
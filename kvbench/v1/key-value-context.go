@@ -0,0 +1,201 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyValueBenchContextWorker is an optional interface of KeyValueBenchWorker.
+// If implemented, operations are called with a context that is cancelled
+// at --op_timeout, and the worker is expected to return once it is done.
+//
+// Workers that do not implement it are adapted automatically: the operation
+// runs in its own goroutine, and is reported as ResultTimeout if it has not
+// returned by the deadline. The client waits for it to return before its
+// next operation.
+type KeyValueBenchContextWorker interface {
+	WriteContext(ctx context.Context, key, value []byte) ResultStatus
+	ReadContext(ctx context.Context, key []byte) ResultStatus
+}
+
+// KeyValueBenchContextDeleter is the context-aware KeyValueBenchDeleter.
+type KeyValueBenchContextDeleter interface {
+	DeleteContext(ctx context.Context, key []byte) ResultStatus
+}
+
+// KeyValueBenchContextScanner is the context-aware KeyValueBenchScanner.
+type KeyValueBenchContextScanner interface {
+	ScanContext(ctx context.Context, start, end []byte, limit int) (int, ResultStatus)
+}
+
+func workerWrite(ctx context.Context, fn KeyValueBenchWorker, key, value []byte) ResultStatus {
+	if fc, ok := fn.(KeyValueBenchContextWorker); ok {
+		return contextResult(ctx, fc.WriteContext(ctx, key, value))
+	}
+	return contextCall(ctx, func() ResultStatus {
+		return fn.Write(key, value)
+	})
+}
+
+func workerRead(ctx context.Context, fn KeyValueBenchWorker, key []byte) ResultStatus {
+	if fc, ok := fn.(KeyValueBenchContextWorker); ok {
+		return contextResult(ctx, fc.ReadContext(ctx, key))
+	}
+	return contextCall(ctx, func() ResultStatus {
+		return fn.Read(key)
+	})
+}
+
+func workerReadValue(ctx context.Context, fn KeyValueBenchWorker, key []byte) ([]byte, ResultStatus) {
+
+	fr, ok := fn.(KeyValueBenchValueReader)
+	if !ok {
+		return nil, ResultERR
+	}
+
+	var value []byte
+	st := contextCall(ctx, func() ResultStatus {
+		var st ResultStatus
+		value, st = fr.ReadValue(key)
+		return st
+	})
+	if st == ResultTimeout {
+		return nil, st
+	}
+
+	return value, st
+}
+
+func workerDelete(ctx context.Context, fn KeyValueBenchWorker, key []byte) ResultStatus {
+	if fc, ok := fn.(KeyValueBenchContextDeleter); ok {
+		return contextResult(ctx, fc.DeleteContext(ctx, key))
+	}
+	if fd, ok := fn.(KeyValueBenchDeleter); ok {
+		return contextCall(ctx, func() ResultStatus {
+			return fd.Delete(key)
+		})
+	}
+	return ResultERR
+}
+
+func workerScan(ctx context.Context, fn KeyValueBenchWorker,
+	start, end []byte, limit int) (int, ResultStatus) {
+
+	if fc, ok := fn.(KeyValueBenchContextScanner); ok {
+		n, st := fc.ScanContext(ctx, start, end, limit)
+		return n, contextResult(ctx, st)
+	}
+
+	if fs, ok := fn.(KeyValueBenchScanner); ok {
+		n := 0
+		st := contextCall(ctx, func() ResultStatus {
+			var st ResultStatus
+			n, st = fs.Scan(start, end, limit)
+			return st
+		})
+		if st == ResultTimeout {
+			return 0, st
+		}
+		return n, st
+	}
+
+	return 0, ResultERR
+}
+
+type keyValueCallsKey struct{}
+
+// opContext returns the context of an operation, with the --op_timeout
+// deadline, and a func which cancels it and waits for the calls abandoned
+// at the deadline. A client calls it before taking its next operation, so
+// its worker is never used by two calls at once, and at most one abandoned
+// call per client is left running.
+func (it *keyValueBenchOptions) opContext() (context.Context, func()) {
+
+	if it.opTimeout < 1 {
+		return context.Background(), func() {}
+	}
+
+	var (
+		calls       sync.WaitGroup
+		ctx, cancel = context.WithTimeout(context.Background(),
+			time.Duration(it.opTimeout)*time.Millisecond)
+	)
+
+	ctx = context.WithValue(ctx, keyValueCallsKey{}, &calls)
+
+	return ctx, func() {
+		cancel()
+		calls.Wait()
+	}
+}
+
+// opWait calls the done func of opContext, and reports whether it returns
+// within timeout.
+func opWait(done func(), timeout time.Duration) bool {
+
+	ch := make(chan bool)
+	go func() {
+		done()
+		close(ch)
+	}()
+
+	select {
+	case <-ch:
+		return true
+
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// contextCall runs call and waits for it until ctx is done. Without a
+// deadline call runs in the calling goroutine.
+func contextCall(ctx context.Context, call func() ResultStatus) ResultStatus {
+
+	if ctx.Done() == nil {
+		return call()
+	}
+
+	calls, _ := ctx.Value(keyValueCallsKey{}).(*sync.WaitGroup)
+	if calls != nil {
+		calls.Add(1)
+	}
+
+	ch := make(chan ResultStatus, 1)
+	go func() {
+		if calls != nil {
+			defer calls.Done()
+		}
+		ch <- call()
+	}()
+
+	select {
+	case st := <-ch:
+		return st
+
+	case <-ctx.Done():
+		return ResultTimeout
+	}
+}
+
+func contextResult(ctx context.Context, st ResultStatus) ResultStatus {
+	if st != ResultOK && ctx.Err() == context.DeadlineExceeded {
+		return ResultTimeout
+	}
+	return st
+}
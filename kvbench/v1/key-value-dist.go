@@ -0,0 +1,221 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	keyDistNameUniform    = "uniform"
+	keyDistNameSequential = "sequential"
+	keyDistNameZipfian    = "zipfian"
+	keyDistNameHotspot    = "hotspot"
+	keyDistNameLatest     = "latest"
+
+	keyDistZipfianTheta = 0.99
+	keyDistHotspotOps   = 80
+	keyDistHotspotKeys  = 20
+)
+
+// keyValueKeyChooser picks the index of the next key to access in a keyspace
+// of n keys.
+type keyValueKeyChooser interface {
+	next(r *mrand.Rand, n int64) int64
+	name() string
+}
+
+func newKeyValueKeyChooser(name string, options *keyValueBenchOptions) (keyValueKeyChooser, error) {
+
+	switch name {
+
+	case keyDistNameUniform:
+		return &keyValueUniformChooser{}, nil
+
+	case keyDistNameSequential:
+		return &keyValueSequentialChooser{}, nil
+
+	case keyDistNameZipfian:
+		return newKeyValueZipfianChooser(options.keyDistTheta, true), nil
+
+	case keyDistNameHotspot:
+		return &keyValueHotspotChooser{
+			hotOps:  options.keyDistHotOps,
+			hotKeys: options.keyDistHotKeys,
+		}, nil
+
+	case keyDistNameLatest:
+		return &keyValueLatestChooser{
+			zipf: newKeyValueZipfianChooser(options.keyDistTheta, false),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid key distribution %q", name)
+}
+
+type keyValueUniformChooser struct{}
+
+func (it *keyValueUniformChooser) next(r *mrand.Rand, n int64) int64 {
+	if n < 1 {
+		return 0
+	}
+	return r.Int63n(n)
+}
+
+func (it *keyValueUniformChooser) name() string {
+	return keyDistNameUniform
+}
+
+// keyValueSequentialChooser walks the keyspace in order and wraps around.
+type keyValueSequentialChooser struct {
+	offset int64
+}
+
+func (it *keyValueSequentialChooser) next(r *mrand.Rand, n int64) int64 {
+	if n < 1 {
+		return 0
+	}
+	return (atomic.AddInt64(&it.offset, 1) - 1) % n
+}
+
+func (it *keyValueSequentialChooser) name() string {
+	return keyDistNameSequential
+}
+
+// keyValueHotspotChooser sends hotOps percent of the accesses to the first
+// hotKeys percent of the keyspace, and the rest to the remaining keys.
+type keyValueHotspotChooser struct {
+	hotOps  int64
+	hotKeys int64
+}
+
+func (it *keyValueHotspotChooser) next(r *mrand.Rand, n int64) int64 {
+
+	if n < 1 {
+		return 0
+	}
+
+	hn := (n * it.hotKeys) / 100
+	if hn < 1 {
+		hn = 1
+	} else if hn >= n {
+		return r.Int63n(n)
+	}
+
+	if r.Int63n(100) < it.hotOps {
+		return r.Int63n(hn)
+	}
+	return hn + r.Int63n(n-hn)
+}
+
+func (it *keyValueHotspotChooser) name() string {
+	return fmt.Sprintf("%s-%d-%d", keyDistNameHotspot, it.hotOps, it.hotKeys)
+}
+
+// keyValueZipfianChooser implements the zipfian generator described in
+// "Quickly Generating Billion-Record Synthetic Databases" (Gray et al.), as
+// used by YCSB. The zeta constant is updated incrementally as n grows.
+type keyValueZipfianChooser struct {
+	mu        sync.Mutex
+	theta     float64
+	alpha     float64
+	zeta2     float64
+	zetan     float64
+	eta       float64
+	n         int64
+	scrambled bool
+}
+
+func newKeyValueZipfianChooser(theta float64, scrambled bool) *keyValueZipfianChooser {
+	return &keyValueZipfianChooser{
+		theta:     theta,
+		alpha:     1.0 / (1.0 - theta),
+		zeta2:     1.0 + math.Pow(0.5, theta),
+		scrambled: scrambled,
+	}
+}
+
+func (it *keyValueZipfianChooser) next(r *mrand.Rand, n int64) int64 {
+
+	if n < 2 {
+		return 0
+	}
+
+	it.mu.Lock()
+	if n != it.n {
+		if n < it.n {
+			it.n, it.zetan = 0, 0
+		}
+		for i := it.n; i < n; i++ {
+			it.zetan += 1.0 / math.Pow(float64(i+1), it.theta)
+		}
+		it.n = n
+		it.eta = (1.0 - math.Pow(2.0/float64(n), 1.0-it.theta)) /
+			(1.0 - it.zeta2/it.zetan)
+	}
+	var (
+		zetan = it.zetan
+		eta   = it.eta
+	)
+	it.mu.Unlock()
+
+	var (
+		u  = r.Float64()
+		uz = u * zetan
+		v  int64
+	)
+
+	if uz < 1.0 {
+		v = 0
+	} else if uz < it.zeta2 {
+		v = 1
+	} else {
+		v = int64(float64(n) * math.Pow(eta*u-eta+1.0, it.alpha))
+	}
+
+	if v >= n {
+		v = n - 1
+	}
+
+	if it.scrambled {
+		v = int64(splitmix64(uint64(v)) % uint64(n))
+	}
+
+	return v
+}
+
+func (it *keyValueZipfianChooser) name() string {
+	return fmt.Sprintf("%s-%g", keyDistNameZipfian, it.theta)
+}
+
+// keyValueLatestChooser biases access to the most recently inserted keys.
+type keyValueLatestChooser struct {
+	zipf *keyValueZipfianChooser
+}
+
+func (it *keyValueLatestChooser) next(r *mrand.Rand, n int64) int64 {
+	if n < 1 {
+		return 0
+	}
+	return n - 1 - it.zipf.next(r, n)
+}
+
+func (it *keyValueLatestChooser) name() string {
+	return fmt.Sprintf("%s-%g", keyDistNameLatest, it.zipf.theta)
+}
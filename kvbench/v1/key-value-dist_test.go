@@ -0,0 +1,83 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKeyValueZipfianChooser(t *testing.T) {
+
+	const (
+		n   = 1000
+		num = 100000
+	)
+
+	for _, theta := range []float64{0.5, 0.8, keyDistZipfianTheta} {
+
+		var (
+			c      = newKeyValueZipfianChooser(theta, false)
+			r      = newRand(1, randStreamOps)
+			counts = make([]int, n)
+		)
+
+		for i := 0; i < num; i++ {
+			k := c.next(r, n)
+			if k < 0 || k >= n {
+				t.Fatalf("theta %g: key %d out of [0, %d)", theta, k, n)
+			}
+			counts[k]++
+		}
+
+		// unscrambled, the popular keys are the first ones, the 10 first
+		// keys get about their share of the zipfian distribution
+		var (
+			top, want float64
+			zetan     float64
+		)
+		for i := 0; i < n; i++ {
+			p := 1 / math.Pow(float64(i+1), theta)
+			if i < 10 {
+				top += float64(counts[i])
+				want += p
+			}
+			zetan += p
+		}
+		if top, want = 100*top/num, 100*want/zetan; math.Abs(top-want) > 2 {
+			t.Fatalf("theta %g: %.1f%% picks of the 10 first keys, want %.1f%%", theta, top, want)
+		}
+		if counts[0] <= counts[n-1] {
+			t.Fatalf("theta %g: key 0 %d picks, key %d %d", theta, counts[0], n-1, counts[n-1])
+		}
+	}
+}
+
+func TestKeyValueZipfianChooserResize(t *testing.T) {
+
+	var (
+		c = newKeyValueZipfianChooser(keyDistZipfianTheta, true)
+		r = newRand(1, randStreamOps)
+	)
+
+	// the keyspace grows with inserts and shrinks with deletes
+	for _, n := range []int64{0, 1, 2, 10, 1000, 100, 1e5} {
+		for i := 0; i < 1000; i++ {
+			if k := c.next(r, n); k < 0 || (n > 0 && k >= n) || (n == 0 && k != 0) {
+				t.Fatalf("n %d: key %d out of range", n, k)
+			}
+		}
+	}
+}
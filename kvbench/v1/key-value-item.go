@@ -15,59 +15,147 @@
 package kvbench
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hooto/hchart/v2/hcapi"
 )
 
 const (
-	readKeysMax = 100000
-	readKeysCap = readKeysMax * 10
+	// requests still in flight after it are left behind at the end of a run
+	keyValueDrainTimeout = 10 * time.Second
 )
 
 type keyValueBenchItem struct {
 	options  *keyValueBenchOptions
 	status   *keyValueBenchStatus
+	opStatus map[int]*keyValueBenchStatus
+	keys     *keyValueKeySpace
 	typ      uint64
-	quit     bool
-	data     chan *keyValueItem
+	rands    []*mrand.Rand // of each client, generate its ops in runClients
+	quit     chan bool     // closed by stop
+	quitOnce sync.Once
+	partial  int32 // interrupted, see KeyValueBench.interrupt
+	attrs    []string
 	datasets hcapi.DataList
+
+	// per client workers, see KeyValueBenchWorkerFactory
+	workers       []KeyValueBenchWorker
+	setupTimes    []int64 // microseconds
+	teardownTimes []int64 // microseconds
+	workerAttrs   []string
 }
 
+// keyValueBenchStatus collects the results of a bench. Clients record them
+// into their shard with atomic operations only, and the shards are merged
+// into the other fields at every timeStep and once the clients are done.
 type keyValueBenchStatus struct {
-	options     *keyValueBenchOptions
+	options        *keyValueBenchOptions
+	shards         []*keyValueBenchStatusShard
+	ok             int64
+	err            int64
+	errs           [resultStatusCap]int64
+	mismatch       int64
+	errMap         []*keyValueWriteUsageItem
+	nps            float64
+	npsMap         []*keyValueWriteUsageItem
+	keys           int64
+	keysMap        []*keyValueWriteUsageItem
+	latencyMap     []*keyValueWriteUsageItem
+	latencyTime    int64
+	latencyHist    *latencyHistogram
+	latencyPrev    *latencyHistogram // latencyHist of the last timeStep
+	latencyStepMap []*keyValueLatencyStepItem
+}
+
+type keyValueBenchStatusShard struct {
 	ok          int64
 	err         int64
-	nps         float64
-	npsMap      []*keyValueWriteUsageItem
-	latencyMap  []*keyValueWriteUsageItem
+	errs        [resultStatusCap]int64
+	mismatch    int64
+	keys        int64
 	latencyTime int64
+	latencyNums []int64 // of latencyMap
+	latencyHist *latencyHistogram
+}
+
+// keyValueLatencyStepItem holds the latency percentiles of one timeStep.
+type keyValueLatencyStepItem struct {
+	time int64
+	p50  int64
+	p99  int64
+	max  int64
 }
 
 func newkeyValueBenchItem(
 	options *keyValueBenchOptions) *keyValueBenchItem {
 	return &keyValueBenchItem{
-		options: options,
-		data:    make(chan *keyValueItem, 100),
-		status: &keyValueBenchStatus{
-			options: options,
-		},
-		quit: false,
+		options:  options,
+		status:   newKeyValueBenchStatus(options),
+		opStatus: map[int]*keyValueBenchStatus{},
+		quit:     make(chan bool),
 	}
 }
 
-func (it *keyValueBenchStatus) sync(v ResultStatus, tc int64) {
+func newKeyValueBenchStatus(
+	options *keyValueBenchOptions) *keyValueBenchStatus {
+	it := &keyValueBenchStatus{
+		options:     options,
+		latencyHist: newLatencyHistogram(),
+		latencyPrev: newLatencyHistogram(),
+	}
+	for _, v := range options.latencyRanges {
+		it.latencyMap = append(it.latencyMap, &keyValueWriteUsageItem{
+			time: v,
+		})
+	}
+	// one shard per CPU is enough to keep the clients off each other
+	n := runtime.GOMAXPROCS(0)
+	if int64(n) > options.clientNum {
+		n = int(options.clientNum)
+	}
+	for i := 0; i < n || i < 1; i++ {
+		it.shards = append(it.shards, &keyValueBenchStatusShard{
+			latencyNums: make([]int64, len(it.latencyMap)),
+			latencyHist: newLatencyHistogram(),
+		})
+	}
+	return it
+}
+
+// sync records the result of op, executed by client.
+func (it *keyValueBenchStatus) sync(client int, op *keyValueOp, v ResultStatus, tc int64) {
+
+	sh := it.shards[client%len(it.shards)]
 
 	//
 	if v == ResultOK {
-		it.ok += 1
+		atomic.AddInt64(&sh.ok, 1)
 	} else {
-		it.err += 1
+		if v < ResultERR || v >= resultStatusCap {
+			v = ResultERR
+		}
+		atomic.AddInt64(&sh.err, 1)
+		atomic.AddInt64(&sh.errs[v], 1)
 	}
 
-	it.latencyTime += tc
+	if op.num > 0 {
+		atomic.AddInt64(&sh.keys, int64(op.num))
+	}
+	if op.mismatch {
+		atomic.AddInt64(&sh.mismatch, 1)
+	}
+
+	atomic.AddInt64(&sh.latencyTime, tc)
+	sh.latencyHist.recordAtomic(tc)
 
 	//
 	if tc > it.options.latencyMax {
@@ -79,39 +167,66 @@ func (it *keyValueBenchStatus) sync(v ResultStatus, tc int64) {
 	//
 	for i := 1; i < len(it.latencyMap); i++ {
 		if tc < it.latencyMap[i].time {
-			it.latencyMap[i-1].num++
+			atomic.AddInt64(&sh.latencyNums[i-1], 1)
 			break
 		}
 	}
 }
 
-func (it *keyValueBenchStatus) npsSet(v int64) {
-	it.npsMap = append(it.npsMap, &keyValueWriteUsageItem{
-		time: v,
-		num:  (it.ok + it.err),
-	})
-}
-
-func (it *keyValueBenchItem) dataCreate() {
+// merge sums up the shards. It must not run concurrently with itself.
+func (it *keyValueBenchStatus) merge() {
 
-	for i := uint64(1); ; i++ {
+	it.ok, it.err, it.mismatch, it.keys, it.latencyTime = 0, 0, 0, 0, 0
+	it.errs = [resultStatusCap]int64{}
+	it.latencyHist.reset()
+	for _, v := range it.latencyMap {
+		v.num = 0
+	}
 
-		if it.quit {
-			break
+	for _, sh := range it.shards {
+		it.ok += atomic.LoadInt64(&sh.ok)
+		it.err += atomic.LoadInt64(&sh.err)
+		for i := range sh.errs {
+			it.errs[i] += atomic.LoadInt64(&sh.errs[i])
 		}
-
-		if uint64Allow(it.typ, BenchTypeRandWrite) {
-			it.data <- &keyValueItem{
-				Key:   randKey(it.options.keySize, 0),
-				Value: randValue(it.options.valueSize),
-			}
-		} else if uint64Allow(it.typ, BenchTypeSeqWrite) {
-			it.data <- &keyValueItem{
-				Key:   randKey(it.options.keySize, i),
-				Value: randValue(it.options.valueSize),
-			}
+		it.mismatch += atomic.LoadInt64(&sh.mismatch)
+		it.keys += atomic.LoadInt64(&sh.keys)
+		it.latencyTime += atomic.LoadInt64(&sh.latencyTime)
+		for i, v := range it.latencyMap {
+			v.num += atomic.LoadInt64(&sh.latencyNums[i])
 		}
+		it.latencyHist.mergeAtomic(sh.latencyHist)
+	}
+}
+
+func (it *keyValueBenchStatus) num() int64 {
+	return it.ok + it.err
+}
+
+func (it *keyValueBenchStatus) npsSet(v int64) {
+	it.merge()
+	it.npsMap = append(it.npsMap, &keyValueWriteUsageItem{
+		time: v,
+		num:  it.num(),
+	})
+	it.errMap = append(it.errMap, &keyValueWriteUsageItem{
+		time: v,
+		num:  it.err,
+	})
+	it.keysMap = append(it.keysMap, &keyValueWriteUsageItem{
+		time: v,
+		num:  it.keys,
+	})
+	if v > 0 {
+		step := it.latencyHist.delta(it.latencyPrev)
+		it.latencyStepMap = append(it.latencyStepMap, &keyValueLatencyStepItem{
+			time: v,
+			p50:  step.percentile(50),
+			p99:  step.percentile(99),
+			max:  step.max,
+		})
 	}
+	*it.latencyPrev = *it.latencyHist
 }
 
 func (it *keyValueBenchItem) run(fn KeyValueBenchWorker) error {
@@ -127,6 +242,29 @@ func (it *keyValueBenchItem) run(fn KeyValueBenchWorker) error {
 		if err := it.runRead(fn); err != nil {
 			return err
 		}
+
+	} else if it.typ == BenchTypeRandDelete ||
+		it.typ == BenchTypeSeqDelete {
+		if err := it.runDelete(fn); err != nil {
+			return err
+		}
+
+	} else if it.typ == BenchTypeRandScan ||
+		it.typ == BenchTypeSeqScan {
+		if err := it.runScan(fn); err != nil {
+			return err
+		}
+
+	} else if it.typ == BenchTypeTrace {
+		if err := it.runTrace(fn); err != nil {
+			return err
+		}
+
+	} else if it.typ == BenchTypeMixed ||
+		benchTypePreset(it.typ) != nil {
+		if err := it.runMixed(fn); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -134,267 +272,624 @@ func (it *keyValueBenchItem) run(fn KeyValueBenchWorker) error {
 
 func (it *keyValueBenchItem) runWrite(fn KeyValueBenchWorker) error {
 
-	cq := make(chan KeyValueBenchWorker, int(it.options.clientNum))
-	for i := 0; i < int(it.options.clientNum); i++ {
-		cq <- fn
+	ks, err := it.keySpace(uint64Allow(it.typ, BenchTypeSeqWrite))
+	if err != nil {
+		return err
 	}
 
-	go it.dataCreate()
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
+		return &keyValueOp{
+			kind:  keyValueOpWrite,
+			key:   ks.key(ks.alloc()),
+			value: randValue(r, it.options.valueSizes.next(r), it.options.valueCompress),
+		}
+	})
+
+	it.datasetsSync(fn)
 
-	for _, v := range it.options.latencyRanges {
-		it.status.latencyMap = append(it.status.latencyMap, &keyValueWriteUsageItem{
-			time: v,
-		})
+	return nil
+}
+
+func (it *keyValueBenchItem) runRead(fn KeyValueBenchWorker) error {
+
+	keyDist := keyDistNameUniform
+
+	if it.typ == BenchTypeSeqRead {
+		keyDist = keyDistNameSequential
+	} else if it.typ != BenchTypeRandRead {
+		return errors.New("invalid settings")
 	}
 
-	var (
-		gts      = time.Now().UnixNano() / 1e3
-		ticker   = time.NewTicker(time.Duration(it.options.timeStep) * time.Second)
-		timeUsed = int64(0)
-	)
-	defer ticker.Stop()
+	ks, err := it.keySpace(it.typ == BenchTypeSeqRead)
+	if err != nil {
+		return err
+	}
 
-	it.status.npsSet(0)
-	go func() {
-		for {
-			select {
-			case _ = <-ticker.C:
-				timeUsed += it.options.timeStep
-				it.status.npsSet(timeUsed)
-				if timeUsed >= it.options.timeLen {
-					it.quit = true
-				}
-			}
-		}
-	}()
+	chooser, err := it.keyChooser(keyDist)
+	if err != nil {
+		return err
+	}
 
-	for {
+	it.preload(fn, ks)
 
-		if it.quit {
-			break
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
+		idx := chooser.next(r, ks.size())
+		return &keyValueOp{
+			kind: keyValueOpRead,
+			idx:  idx,
+			key:  ks.key(idx),
 		}
+	})
 
-		v := <-it.data
-		q := <-cq
-		go func(q KeyValueBenchWorker, kv *keyValueItem) {
+	it.datasetsSync(fn)
 
-			ts := time.Now().UnixNano() / 1e3
-			st := q.Write(kv.Key, kv.Value)
-			tc := (time.Now().UnixNano() / 1e3) - ts
+	return nil
+}
 
-			it.status.sync(st, tc)
+func (it *keyValueBenchItem) runDelete(fn KeyValueBenchWorker) error {
 
-			cq <- q
-		}(q, v)
+	if _, ok := fn.(KeyValueBenchDeleter); !ok {
+		return errors.New("delete bench requires a KeyValueBenchDeleter worker")
 	}
 
-	done := 0
+	ks, err := it.keySpace(it.typ == BenchTypeSeqDelete)
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
+	it.preload(fn, ks)
 
-		case _ = <-cq:
-			done += 1
-			if done >= int(it.options.clientNum) {
-				it.quit = true
-			}
+	// each preloaded key is deleted once, in index order, which is random
+	// key order for rand-delete and ascending key order for seq-delete
+	offset := int64(0)
+
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
+		if offset >= ks.size() {
+			return nil
+		}
+		op := &keyValueOp{
+			kind: keyValueOpDelete,
+			idx:  offset,
+			key:  ks.key(offset),
 		}
+		offset++
+		return op
+	})
 
-		if it.quit {
-			break
+	it.datasetsSync(fn)
+
+	return nil
+}
+
+func (it *keyValueBenchItem) runScan(fn KeyValueBenchWorker) error {
+
+	if _, ok := fn.(KeyValueBenchScanner); !ok {
+		return errors.New("scan bench requires a KeyValueBenchScanner worker")
+	}
+
+	var (
+		keyDist = keyDistNameUniform
+		scanLen = int64(it.options.scanLength)
+	)
+
+	ks, err := it.keySpace(true)
+	if err != nil {
+		return err
+	}
+
+	if it.typ == BenchTypeSeqScan {
+		keyDist = keyDistNameSequential
+	}
+
+	chooser, err := it.keyChooser(keyDist)
+	if err != nil {
+		return err
+	}
+
+	it.preload(fn, ks)
+	it.attrs = append(it.attrs, fmt.Sprintf("scan-length:%d", scanLen))
+
+	// scan ranges of scanLen keys, starting at a chosen range
+	ranges := ks.size() / scanLen
+	if ranges < 1 {
+		ranges = 1
+	}
+
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
+		idx := chooser.next(r, ranges) * scanLen
+		return &keyValueOp{
+			kind:  keyValueOpScan,
+			idx:   idx,
+			key:   ks.key(idx),
+			end:   ks.key(idx + scanLen),
+			limit: int(scanLen),
 		}
+	})
+
+	it.datasetsSync(fn)
+
+	return nil
+}
+
+// keySpace returns a keyspace of --key_format. The keys of a seq keyspace
+// are accessed in key order, so it requires an ordered format.
+func (it *keyValueBenchItem) keySpace(seq bool) (*keyValueKeySpace, error) {
+
+	// a seq-write allocates keys while it runs, the other benches use the
+	// preloaded ones
+	n := uint64(math.MaxUint64)
+	if it.typ != BenchTypeSeqWrite {
+		n = uint64(it.options.preloadKeys)
 	}
 
-	gtc := (time.Now().UnixNano() / 1e3) - gts
-	if gtc < 1 {
-		gtc = 1
+	f := it.options.keyFormat
+	if seq && !f.ordered(n) {
+		return nil, fmt.Errorf("bench %s requires ordered keys, key format %s is not",
+			benchTypeName(it.typ), f.name())
 	}
 
-	it.status.nps = (float64(it.status.ok+it.status.err) / float64(gtc)) * 1e6
+	return newKeyValueKeySpace(f, seq), nil
+}
+
+// keyChooser returns the key chooser set by --key_dist, or the one named
+// by def if no distribution was set.
+func (it *keyValueBenchItem) keyChooser(def string) (keyValueKeyChooser, error) {
 
-	if it.status.ok > 0 && len(it.status.npsMap) > 0 {
+	name := def
+	if it.options.keyDist != "" {
+		name = it.options.keyDist
+	}
 
-		ds := hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("throughput")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+	chooser, err := newKeyValueKeyChooser(name, it.options)
+	if err != nil {
+		return nil, err
+	}
+	it.attrs = append(it.attrs, "key-dist:"+chooser.name())
+
+	return chooser, nil
+}
+
+func (it *keyValueBenchItem) runMixed(fn KeyValueBenchWorker) error {
+
+	var (
+		ratios     = it.options.opRatios
+		keyDist    = keyDistNameUniform
+		scanLenMax = it.options.scanLength
+	)
+
+	if p := benchTypePreset(it.typ); p != nil {
+		var err error
+		if ratios, err = newKeyValueOpRatios(p.opRatio); err != nil {
+			return err
 		}
+		keyDist, scanLenMax = p.keyDist, p.scanLenMax
+	}
 
-		for _, v := range it.status.npsMap {
+	if ratios.has(keyValueOpDelete) {
+		if _, ok := fn.(KeyValueBenchDeleter); !ok {
+			return errors.New("delete op requires a KeyValueBenchDeleter worker")
+		}
+	}
 
-			ds.Points = append(ds.Points, &hcapi.DataPoint{
-				X: float64(v.time),
-				Y: float64(v.num),
-			})
+	if ratios.has(keyValueOpScan) {
+		if _, ok := fn.(KeyValueBenchScanner); !ok {
+			return errors.New("scan op requires a KeyValueBenchScanner worker")
 		}
+	}
 
-		it.datasets.Set(ds)
+	chooser, err := it.keyChooser(keyDist)
+	if err != nil {
+		return err
+	}
+
+	ks, err := it.keySpace(false)
+	if err != nil {
+		return err
 	}
+	it.preload(fn, ks)
 
-	if it.status.ok > 0 && len(it.status.latencyMap) > 0 {
+	for _, v := range ratios {
+		it.opStatus[v.kind] = newKeyValueBenchStatus(it.options)
+	}
+	it.attrs = append(it.attrs, "op-ratio:"+ratios.name())
+
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
 
-		ds := hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("latency-avg")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+		op := &keyValueOp{
+			kind: ratios.next(r),
 		}
-		ds.Points = append(ds.Points, &hcapi.DataPoint{
-			Y: float64Round(float64(it.status.latencyTime)/float64(it.status.ok), 4),
-		})
-		it.datasets.Set(ds)
 
-		ds = hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("latency")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+		if op.kind == keyValueOpInsert {
+			op.idx = ks.alloc()
+		} else {
+			op.idx = chooser.next(r, ks.size())
 		}
+		op.key = ks.key(op.idx)
 
-		for _, v := range it.status.latencyMap {
+		switch op.kind {
+		case keyValueOpWrite, keyValueOpInsert, keyValueOpRMW:
+			op.value = randValue(r, it.options.valueSizes.next(r),
+				it.options.valueCompress)
 
-			ds.Points = append(ds.Points, &hcapi.DataPoint{
-				X: float64(v.time),
-				Y: float64(v.num),
-			})
+		case keyValueOpScan:
+			op.limit = 1 + r.Intn(scanLenMax)
 		}
 
-		it.datasets.Set(ds)
-	}
+		return op
+	})
+
+	it.datasetsSync(fn)
 
 	return nil
 }
 
-func (it *keyValueBenchItem) runRead(fn KeyValueBenchWorker) error {
+func (it *keyValueBenchItem) opExec(ctx context.Context,
+	fn KeyValueBenchWorker, op *keyValueOp) ResultStatus {
 
-	keys := make(chan []byte, readKeysMax+10)
-
-	if it.typ == BenchTypeSeqRead ||
-		it.typ == BenchTypeRandRead {
+	if it.options.verify && it.keys != nil {
+		switch op.kind {
+		case keyValueOpWrite, keyValueOpDelete, keyValueOpRMW:
+			it.keys.sumReset(op.idx)
+		}
+	}
 
-		for i := 0; i < readKeysCap; i++ {
+	switch op.kind {
 
-			var (
-				key []byte
-				val = RandBytes(it.options.valueSize)
-			)
+	case keyValueOpWrite:
+		return workerWrite(ctx, fn, op.key, op.value)
 
-			if it.typ == BenchTypeSeqRead {
-				key = []byte(RandHexString(it.options.keySize-16) + uint64ToHexString(uint64(i)))
-			} else {
-				key = []byte(RandHexString(it.options.keySize))
+	case keyValueOpRead:
+		if it.options.verify && it.keys != nil {
+			val, st := workerReadValue(ctx, fn, op.key)
+			if st == ResultOK && !it.keys.verify(op.idx, val) {
+				op.mismatch = true
 			}
+			return st
+		}
+		return workerRead(ctx, fn, op.key)
 
-			fn.Write(key, val)
-			if len(keys) < readKeysMax {
-				keys <- key
-			}
+	case keyValueOpDelete:
+		return workerDelete(ctx, fn, op.key)
+
+	case keyValueOpInsert:
+		st := workerWrite(ctx, fn, op.key, op.value)
+		if st == ResultOK {
+			it.keys.commit(op.idx)
 		}
+		return st
 
-	} else {
-		return errors.New("invalid settings")
-	}
+	case keyValueOpScan:
+		n, st := workerScan(ctx, fn, op.key, op.end, op.limit)
+		op.num = n
+		return st
 
-	cq := make(chan KeyValueBenchWorker, int(it.options.clientNum))
-	for i := 0; i < int(it.options.clientNum); i++ {
-		cq <- fn
+	case keyValueOpRMW:
+		if st := workerRead(ctx, fn, op.key); st != ResultOK {
+			return st
+		}
+		return workerWrite(ctx, fn, op.key, op.value)
 	}
 
-	for _, v := range it.options.latencyRanges {
-		it.status.latencyMap = append(it.status.latencyMap, &keyValueWriteUsageItem{
-			time: v,
-		})
+	return ResultERR
+}
+
+// runClients keeps clientNum requests in flight, each one taken from opNext
+// with the generator of the client which runs it, until the configured time
+// is used up or opNext returns nil.
+//
+// With a target --rate the load is open-loop: requests are scheduled on a
+// fixed timeline and latency is measured from the intended start time, so
+// time spent waiting for a free client is counted as well. Timed ops are
+// scheduled the same way, at their own start time.
+func (it *keyValueBenchItem) runClients(fn KeyValueBenchWorker, opNext func(r *mrand.Rand) *keyValueOp) {
+
+	cq := make(chan int, int(it.options.clientNum))
+	for i := 0; i < int(it.options.clientNum); i++ {
+		cq <- i
+		it.rands = append(it.rands, newRand(it.options.seed, randStreamOps+uint64(i)))
 	}
 
 	var (
-		gts      = time.Now().UnixNano() / 1e3
-		ticker   = time.NewTicker(time.Duration(it.options.timeStep) * time.Second)
-		timeUsed = int64(0)
+		gts        = time.Now().UnixNano() / 1e3
+		mts        = gts + it.options.warmup*1e6 // measurement start
+		timeUsed   = int64(0)
+		opNum      = int64(0)
+		opInterval = float64(0) // microseconds
+		tickerStop = make(chan bool)
+		tickerDone = make(chan bool)
 	)
-	defer ticker.Stop()
 
-	it.status.npsSet(0)
+	if it.options.rate > 0 {
+		opInterval = 1e6 / float64(it.options.rate)
+		it.attrs = append(it.attrs, fmt.Sprintf("rate:%d", it.options.rate))
+	}
+
+	// load is applied during the warm-up, but the requests started before
+	// mts are not recorded, and the time line starts after it
+	if it.options.warmup > 0 {
+		it.attrs = append(it.attrs, fmt.Sprintf("warmup:%d", it.options.warmup))
+	} else {
+		it.npsSet(0)
+	}
+
 	go func() {
+		defer close(tickerDone)
+		if it.options.warmup > 0 {
+			select {
+			case <-time.After(time.Duration(it.options.warmup) * time.Second):
+				it.npsSet(0)
+			case <-tickerStop:
+				return
+			}
+		}
+		ticker := time.NewTicker(time.Duration(it.options.timeStep) * time.Second)
+		defer ticker.Stop()
 		for {
 			select {
 			case _ = <-ticker.C:
 				timeUsed += it.options.timeStep
-				it.status.npsSet(timeUsed)
+				it.npsSet(timeUsed)
 				if timeUsed >= it.options.timeLen {
-					it.quit = true
+					it.stop()
+					return
 				}
+			case <-tickerStop:
+				return
 			}
 		}
 	}()
 
-	for {
+	for !it.stopped() {
 
-		if it.quit {
+		var c int
+		select {
+		case c = <-cq:
+		case <-it.quit:
+			continue
+		}
+
+		v := opNext(it.rands[c])
+		if v == nil {
+			cq <- c
+			it.stop()
 			break
 		}
 
-		v := <-keys
-		q := <-cq
-		go func(q KeyValueBenchWorker, k []byte) {
+		ots := int64(0)
+		if v.timed {
+			ots = gts + v.at
+		} else if opInterval > 0 {
+			ots = gts + int64(float64(opNum)*opInterval)
+			opNum += 1
+		}
+
+		if ots > 0 {
+			// timers oversleep by up to a millisecond, which would be
+			// counted as latency, so only sleep for the bulk of the wait
+			if d := ots - (time.Now().UnixNano() / 1e3); d > 2000 {
+				time.Sleep(time.Duration(d-1000) * time.Microsecond)
+			}
+			for (time.Now().UnixNano() / 1e3) < ots {
+				runtime.Gosched()
+			}
+		}
+
+		go func(c int, op *keyValueOp, ots int64) {
+
+			q := fn
+			if len(it.workers) > 0 {
+				q = it.workers[c]
+			}
+
+			ctx, done := it.options.opContext()
 
 			ts := time.Now().UnixNano() / 1e3
-			st := q.Read(k)
+			if ots > 0 {
+				ts = ots
+			}
+			st := it.opExec(ctx, q, op)
 			tc := (time.Now().UnixNano() / 1e3) - ts
 
-			it.status.sync(st, tc)
+			if ts >= mts {
+				it.status.sync(c, op, st, tc)
+				if ost, ok := it.opStatus[op.kind]; ok {
+					ost.sync(c, op, st, tc)
+				}
+			}
+
+			// a timed out call still holds the worker until it returns
+			done()
 
-			keys <- k
-			cq <- q
-		}(q, v)
+			cq <- c
+		}(c, v, ots)
 	}
 
-	done := 0
-
-	for {
+	// wait for the requests in flight
+	drain := time.After(keyValueDrainTimeout)
+	for done := 0; done < int(it.options.clientNum); {
 		select {
-
-		case _ = <-cq:
+		case <-cq:
 			done += 1
-			if done >= int(it.options.clientNum) {
-				it.quit = true
-			}
-		}
 
-		if it.quit {
-			break
+		case <-drain:
+			fmt.Printf("Bench %s: %d requests in flight not done in %v\n",
+				benchTypeName(it.typ), int(it.options.clientNum)-done, keyValueDrainTimeout)
+			done = int(it.options.clientNum)
 		}
 	}
 
-	gtc := (time.Now().UnixNano() / 1e3) - gts
+	gtc := (time.Now().UnixNano() / 1e3) - mts
 	if gtc < 1 {
 		gtc = 1
 	}
 
-	it.status.nps = (float64(it.status.ok+it.status.err) / float64(gtc)) * 1e6
+	close(tickerStop)
+	<-tickerDone
+
+	it.status.merge()
+	for _, ost := range it.opStatus {
+		ost.merge()
+	}
+
+	it.status.nps = (float64(it.status.num()) / float64(gtc)) * 1e6
+	for _, ost := range it.opStatus {
+		ost.nps = (float64(ost.num()) / float64(gtc)) * 1e6
+	}
+}
 
-	if it.status.ok > 0 && len(it.status.npsMap) > 0 {
+// stop ends the run, requests in flight are drained.
+func (it *keyValueBenchItem) stop() {
+	it.quitOnce.Do(func() {
+		close(it.quit)
+	})
+}
+
+func (it *keyValueBenchItem) stopped() bool {
+	select {
+	case <-it.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupt stops the run, and marks its datasets as partial.
+func (it *keyValueBenchItem) interrupt() {
+	atomic.StoreInt32(&it.partial, 1)
+	it.stop()
+}
 
-		ds := hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("throughput")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+func (it *keyValueBenchItem) interrupted() bool {
+	return atomic.LoadInt32(&it.partial) == 1
+}
+
+func (it *keyValueBenchItem) verifyError() error {
+	if it.status.mismatch > 0 {
+		return fmt.Errorf("bench %s: verify failed, %d mismatched values",
+			benchTypeName(it.typ), it.status.mismatch)
+	}
+	return nil
+}
+
+// clientsSetup creates the worker of every client by factory.
+func (it *keyValueBenchItem) clientsSetup(factory KeyValueBenchWorkerFactory) error {
+
+	for i := 0; i < int(it.options.clientNum); i++ {
+
+		ts := time.Now().UnixNano() / 1e3
+		w, err := factory(i)
+		tc := (time.Now().UnixNano() / 1e3) - ts
+
+		if err == nil && w == nil {
+			err = errors.New("worker factory returns nil")
+		}
+		if err != nil {
+			it.clientsTeardown()
+			return fmt.Errorf("client %d setup: %s", i, err.Error())
+		}
+
+		it.workers = append(it.workers, w)
+		it.setupTimes = append(it.setupTimes, tc)
+	}
+
+	return nil
+}
+
+func (it *keyValueBenchItem) clientsTeardown() {
+
+	for _, w := range it.workers {
+
+		ts := time.Now().UnixNano() / 1e3
+		if c, ok := w.(io.Closer); ok {
+			c.Close()
+		}
+		tc := (time.Now().UnixNano() / 1e3) - ts
+
+		it.teardownTimes = append(it.teardownTimes, tc)
+	}
+
+	it.workers = nil
+}
+
+// clientDatasetsSync records the setup and teardown times of the clients,
+// with the worker attributes taken before the teardown.
+func (it *keyValueBenchItem) clientDatasetsSync() {
+
+	for _, v := range []struct {
+		attr  string
+		times []int64
+	}{
+		{"client-setup", it.setupTimes},
+		{"client-teardown", it.teardownTimes},
+	} {
+
+		if len(v.times) < 1 {
+			continue
 		}
 
-		for _, v := range it.status.npsMap {
+		ds := it.workerDatasetNew(it.workerAttrs, v.attr)
+		for i, tc := range v.times {
+			ds.Points = append(ds.Points, &hcapi.DataPoint{
+				X: float64(i),
+				Y: float64(tc),
+			})
+		}
+
+		it.datasets.Set(ds)
+	}
+}
+
+func (it *keyValueBenchItem) npsSet(v int64) {
+	it.status.npsSet(v)
+	for _, ost := range it.opStatus {
+		ost.npsSet(v)
+	}
+}
+
+func (it *keyValueBenchItem) datasetsSync(fn KeyValueBenchWorker) {
+
+	if len(it.opStatus) == 0 {
+		it.statusDatasetsSync(fn, it.status)
+		return
+	}
+
+	it.statusDatasetsSync(fn, it.status, "op:all")
+	for kind, ost := range it.opStatus {
+		it.statusDatasetsSync(fn, ost, "op:"+keyValueOpName(kind))
+	}
+}
+
+func (it *keyValueBenchItem) datasetNew(fn KeyValueBenchWorker, attrs ...string) *hcapi.DataItem {
+	return it.workerDatasetNew(fn.Attrs(), attrs...)
+}
+
+// workerDatasetNew is datasetNew with the attributes of the worker already
+// taken, for the datasets built once the workers are closed.
+func (it *keyValueBenchItem) workerDatasetNew(workerAttrs []string, attrs ...string) *hcapi.DataItem {
+
+	ds := it.options.datasetNew(it.typ, it.interrupted(), attrs...)
+	ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
+	for _, av := range it.attrs {
+		ds.AttrSet(av)
+	}
+	if len(it.setupTimes) > 0 {
+		ds.AttrSet("worker:per-client")
+	}
+	for _, av := range workerAttrs {
+		ds.AttrSet(av)
+	}
+
+	return ds
+}
+
+func (it *keyValueBenchItem) statusDatasetsSync(fn KeyValueBenchWorker,
+	st *keyValueBenchStatus, attrs ...string) {
+
+	if st.ok > 0 && len(st.npsMap) > 0 {
+
+		ds := it.datasetNew(fn, append([]string{"throughput"}, attrs...)...)
+
+		for _, v := range st.npsMap {
 
 			ds.Points = append(ds.Points, &hcapi.DataPoint{
 				X: float64(v.time),
@@ -405,44 +900,161 @@ func (it *keyValueBenchItem) runRead(fn KeyValueBenchWorker) error {
 		it.datasets.Set(ds)
 	}
 
-	if it.status.ok > 0 && len(it.status.latencyMap) > 0 {
+	if st.err > 0 {
+
+		for v := ResultERR; v < resultStatusCap; v++ {
+
+			if st.errs[v] < 1 {
+				continue
+			}
 
-		ds := hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("latency-avg")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+			ds := it.datasetNew(fn, append([]string{"errors",
+				"result:" + resultStatusName(v)}, attrs...)...)
+			ds.Points = append(ds.Points, &hcapi.DataPoint{
+				Y: float64(st.errs[v]),
+			})
+
+			it.datasets.Set(ds)
 		}
+	}
+
+	if it.options.verify {
+
+		ds := it.datasetNew(fn, append([]string{"verify-mismatch"}, attrs...)...)
 		ds.Points = append(ds.Points, &hcapi.DataPoint{
-			Y: float64Round(float64(it.status.latencyTime)/float64(it.status.ok), 4),
+			Y: float64(st.mismatch),
 		})
+
 		it.datasets.Set(ds)
+	}
 
-		//
-		ds = hcapi.NewDataItem(it.options.dataName)
-		ds.AttrSet(benchTypeName(it.typ))
-		ds.AttrSet("latency")
-		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
-		ds.AttrSet(fmt.Sprintf("key-value-size:%d-%d",
-			it.options.keySize, it.options.valueSize))
-		for _, av := range fn.Attrs() {
-			ds.AttrSet(av)
+	// error rate in percent of every timeStep
+	if st.err > 0 && len(st.errMap) > 1 && len(st.errMap) == len(st.npsMap) {
+
+		ds := it.datasetNew(fn, append([]string{"error-rate"}, attrs...)...)
+
+		for i := 1; i < len(st.errMap); i++ {
+
+			var (
+				num  = st.npsMap[i].num - st.npsMap[i-1].num
+				rate = float64(0)
+			)
+			if num > 0 {
+				rate = float64Round(float64(100*(st.errMap[i].num-st.errMap[i-1].num))/float64(num), 4)
+			}
+
+			ds.Points = append(ds.Points, &hcapi.DataPoint{
+				X: float64(st.errMap[i].time),
+				Y: rate,
+			})
 		}
 
-		for _, v := range it.status.latencyMap {
+		it.datasets.Set(ds)
+	}
+
+	if st.keys > 0 && len(st.keysMap) > 0 {
+
+		ds := it.datasetNew(fn, append([]string{"throughput-keys"}, attrs...)...)
+
+		for _, v := range st.keysMap {
 
 			ds.Points = append(ds.Points, &hcapi.DataPoint{
 				X: float64(v.time),
 				Y: float64(v.num),
-				// Y: float64Round(float64(100*v.num)/n, 4),
 			})
 		}
 
 		it.datasets.Set(ds)
 	}
 
-	return nil
+	if st.ok > 0 && len(st.latencyMap) > 0 {
+
+		ds := it.datasetNew(fn, append([]string{"latency-avg"}, attrs...)...)
+		ds.Points = append(ds.Points, &hcapi.DataPoint{
+			Y: float64Round(float64(st.latencyTime)/float64(st.ok), 4),
+		})
+		it.datasets.Set(ds)
+
+		//
+		ds = it.datasetNew(fn, append([]string{"latency"}, attrs...)...)
+
+		for _, v := range st.latencyMap {
+
+			ds.Points = append(ds.Points, &hcapi.DataPoint{
+				X: float64(v.time),
+				Y: float64(v.num),
+			})
+		}
+
+		it.datasets.Set(ds)
+	}
+
+	if st.ok > 0 && st.latencyHist.total > 0 {
+
+		ds := it.datasetNew(fn, append([]string{"latency-percentiles"}, attrs...)...)
+
+		for _, p := range latencyPercentiles {
+			ds.Points = append(ds.Points, &hcapi.DataPoint{
+				X: p,
+				Y: float64(st.latencyHist.percentile(p)),
+			})
+		}
+
+		it.datasets.Set(ds)
+	}
+
+	// closed-loop latencies corrected for coordinated omission, using the
+	// configured or the average latency as the expected request interval
+	if st.ok > 0 && st.latencyHist.total > 0 && it.options.rate == 0 {
+
+		interval := it.options.coInterval
+		if interval < 1 {
+			interval = st.latencyTime / st.latencyHist.total
+		}
+
+		if interval > 0 {
+
+			var (
+				ds = it.datasetNew(fn, append([]string{"latency-percentiles-co"}, attrs...)...)
+				hc = st.latencyHist.corrected(interval)
+			)
+
+			for _, p := range latencyPercentiles {
+				ds.Points = append(ds.Points, &hcapi.DataPoint{
+					X: p,
+					Y: float64(hc.percentile(p)),
+				})
+			}
+
+			it.datasets.Set(ds)
+		}
+	}
+
+	if st.ok > 0 && len(st.latencyStepMap) > 0 {
+
+		var (
+			p50 = it.datasetNew(fn, append([]string{"latency-p50"}, attrs...)...)
+			p99 = it.datasetNew(fn, append([]string{"latency-p99"}, attrs...)...)
+			max = it.datasetNew(fn, append([]string{"latency-max"}, attrs...)...)
+		)
+
+		for _, v := range st.latencyStepMap {
+			p50.Points = append(p50.Points, &hcapi.DataPoint{
+				X: float64(v.time),
+				Y: float64(v.p50),
+			})
+			p99.Points = append(p99.Points, &hcapi.DataPoint{
+				X: float64(v.time),
+				Y: float64(v.p99),
+			})
+			max.Points = append(max.Points, &hcapi.DataPoint{
+				X: float64(v.time),
+				Y: float64(v.max),
+			})
+		}
+
+		it.datasets.Set(p50)
+		it.datasets.Set(p99)
+		it.datasets.Set(max)
+	}
 }
@@ -0,0 +1,375 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyFormatNameHex       = "hex"
+	keyFormatNameBinary    = "binary"
+	keyFormatNameComposite = "composite"
+	keyFormatNameUUIDv4    = "uuidv4"
+	keyFormatNameUUIDv7    = "uuidv7"
+	keyFormatNameTemplate  = "template"
+
+	keyFormatCompositePrefix = "kvbench/"
+
+	// unix time in milliseconds of the first uuidv7 key, 2020-01-01
+	keyFormatUUIDv7Base = 1577836800000
+)
+
+// keyValueKeyFormat generates the key of index i of a keyspace. In a seq
+// keyspace the key is generated from the index itself, and ordered(n) tells
+// if the keys of the indexes below n sort in index order. Otherwise the key
+// is generated from a hash of the index, which scatters the keys.
+type keyValueKeyFormat interface {
+	key(i uint64, seq bool) []byte
+	ordered(n uint64) bool
+	size() int
+	name() string
+}
+
+func newKeyValueKeyFormat(name string, options *keyValueBenchOptions) (keyValueKeyFormat, error) {
+
+	switch name {
+
+	case keyFormatNameHex:
+		if options.keySize < 16 {
+			return nil, errors.New("hex key format requires --key_size >= 16")
+		}
+		return &keyValueHexKeyFormat{
+			keySize: options.keySize,
+			prefix:  hexKey(^uint64(keySpaceSeed), options.keySize-16),
+		}, nil
+
+	case keyFormatNameBinary:
+		if options.keySize < 8 {
+			return nil, errors.New("binary key format requires --key_size >= 8")
+		}
+		return &keyValueBinaryKeyFormat{
+			keySize: options.keySize,
+		}, nil
+
+	case keyFormatNameComposite:
+		prefix := options.keyPrefix
+		if prefix == "" {
+			prefix = keyFormatCompositePrefix
+		}
+		return &keyValueCompositeKeyFormat{
+			prefix: []byte(prefix),
+		}, nil
+
+	case keyFormatNameUUIDv4:
+		return &keyValueUUIDKeyFormat{}, nil
+
+	case keyFormatNameUUIDv7:
+		return &keyValueUUIDKeyFormat{v7: true}, nil
+
+	case keyFormatNameTemplate:
+		return newKeyValueTemplateKeyFormat(options.keyTemplate)
+	}
+
+	return nil, fmt.Errorf("invalid key format %q", name)
+}
+
+// keyHash returns the hash a key of a random keyspace is generated from.
+func keyHash(i uint64) uint64 {
+	return splitmix64(keySpaceSeed ^ i)
+}
+
+// keyValueHexKeyFormat generates hex keys of keySize characters. Seq keys
+// are a fixed prefix followed by the big-endian index.
+type keyValueHexKeyFormat struct {
+	keySize int
+	prefix  string
+}
+
+func (it *keyValueHexKeyFormat) key(i uint64, seq bool) []byte {
+	if seq {
+		return []byte(it.prefix + uint64ToHexString(i))
+	}
+	return []byte(hexKey(keySpaceSeed^i, it.keySize))
+}
+
+func (it *keyValueHexKeyFormat) ordered(n uint64) bool { return true }
+func (it *keyValueHexKeyFormat) size() int             { return it.keySize }
+func (it *keyValueHexKeyFormat) name() string          { return keyFormatNameHex }
+
+// keyValueBinaryKeyFormat generates binary keys of keySize bytes. Seq keys
+// are zero padded big-endian indexes.
+type keyValueBinaryKeyFormat struct {
+	keySize int
+}
+
+func (it *keyValueBinaryKeyFormat) key(i uint64, seq bool) []byte {
+	if seq {
+		bs := make([]byte, it.keySize)
+		binary.BigEndian.PutUint64(bs[it.keySize-8:], i)
+		return bs
+	}
+	return hashBytes(keySpaceSeed^i, it.keySize)
+}
+
+func (it *keyValueBinaryKeyFormat) ordered(n uint64) bool { return true }
+func (it *keyValueBinaryKeyFormat) size() int             { return it.keySize }
+func (it *keyValueBinaryKeyFormat) name() string          { return keyFormatNameBinary }
+
+// keyValueCompositeKeyFormat generates keys of a namespace prefix such as
+// tenant/table/ followed by a big-endian id.
+type keyValueCompositeKeyFormat struct {
+	prefix []byte
+}
+
+func (it *keyValueCompositeKeyFormat) key(i uint64, seq bool) []byte {
+	if !seq {
+		i = keyHash(i)
+	}
+	bs := make([]byte, len(it.prefix)+8)
+	copy(bs, it.prefix)
+	binary.BigEndian.PutUint64(bs[len(it.prefix):], i)
+	return bs
+}
+
+func (it *keyValueCompositeKeyFormat) ordered(n uint64) bool { return true }
+func (it *keyValueCompositeKeyFormat) size() int             { return len(it.prefix) + 8 }
+func (it *keyValueCompositeKeyFormat) name() string          { return keyFormatNameComposite }
+
+// keyValueUUIDKeyFormat generates UUID keys in text form. Version 4 keys
+// are random, version 7 keys carry a millisecond timestamp advanced by the
+// index every 4096 keys, so seq keys sort in index order.
+type keyValueUUIDKeyFormat struct {
+	v7 bool
+}
+
+func (it *keyValueUUIDKeyFormat) key(i uint64, seq bool) []byte {
+
+	var (
+		bs = hashBytes(keySpaceSeed^i, 16)
+		id [36]byte
+	)
+
+	if it.v7 {
+		if !seq {
+			i = keyHash(i) >> 24
+		}
+		ts := uint64(keyFormatUUIDv7Base) + i>>12
+		binary.BigEndian.PutUint64(bs[0:], ts<<16|0x7000|(i&0xfff))
+	} else {
+		bs[6] = 0x40 | bs[6]&0x0f
+	}
+	bs[8] = 0x80 | bs[8]&0x3f
+
+	hex.Encode(id[0:8], bs[0:4])
+	hex.Encode(id[9:13], bs[4:6])
+	hex.Encode(id[14:18], bs[6:8])
+	hex.Encode(id[19:23], bs[8:10])
+	hex.Encode(id[24:], bs[10:])
+	id[8], id[13], id[18], id[23] = '-', '-', '-', '-'
+
+	return id[:]
+}
+
+func (it *keyValueUUIDKeyFormat) ordered(n uint64) bool { return it.v7 }
+func (it *keyValueUUIDKeyFormat) size() int             { return 36 }
+
+func (it *keyValueUUIDKeyFormat) name() string {
+	if it.v7 {
+		return keyFormatNameUUIDv7
+	}
+	return keyFormatNameUUIDv4
+}
+
+// keyValueTemplateKeyFormat generates keys from a user template such as
+// "user/{mod:16}/{id:12}/{hex:8}", where
+//
+//	{id} or {id:N}   the decimal id, zero padded to N (default 20) digits
+//	{mod:N}          the decimal id modulo N, e.g. a tenant number
+//	{hex:N}          N hex characters generated from the id
+//
+// The id is the index in a seq keyspace and a hash of it otherwise.
+type keyValueTemplateKeyFormat struct {
+	template string
+	parts    []*keyValueTemplatePart
+	idWidth  uint64 // of the first field if it is {id}, else 0
+	keySize  int
+}
+
+type keyValueTemplatePart struct {
+	text string
+	kind string
+	n    uint64
+}
+
+func newKeyValueTemplateKeyFormat(s string) (*keyValueTemplateKeyFormat, error) {
+
+	if s == "" {
+		return nil, errors.New("template key format requires --key_template")
+	}
+
+	it := &keyValueTemplateKeyFormat{
+		template: s,
+	}
+
+	for s != "" {
+
+		n := strings.IndexByte(s, '{')
+		if n < 0 {
+			it.parts = append(it.parts, &keyValueTemplatePart{text: s})
+			break
+		}
+		if n > 0 {
+			it.parts = append(it.parts, &keyValueTemplatePart{text: s[:n]})
+		}
+
+		m := strings.IndexByte(s[n:], '}')
+		if m < 0 {
+			return nil, fmt.Errorf("invalid key template %q, missing }", it.template)
+		}
+
+		var (
+			field = strings.SplitN(s[n+1:n+m], ":", 2)
+			part  = &keyValueTemplatePart{kind: field[0]}
+		)
+		if len(field) == 2 {
+			v, err := strconv.ParseUint(field[1], 10, 64)
+			if err != nil || v < 1 {
+				return nil, fmt.Errorf("invalid key template field {%s}", s[n+1:n+m])
+			}
+			part.n = v
+		}
+
+		switch part.kind {
+
+		case "id":
+			if part.n == 0 {
+				part.n = 20
+			}
+
+		case "mod", "hex":
+			if part.n == 0 {
+				return nil, fmt.Errorf("invalid key template field {%s}, requires :N", part.kind)
+			}
+
+		default:
+			return nil, fmt.Errorf("invalid key template field {%s}", part.kind)
+		}
+
+		it.parts = append(it.parts, part)
+		s = s[n+m+1:]
+	}
+
+	// keys sort in id order if the id is the first field, as long as the
+	// ids fit its width
+	for _, v := range it.parts {
+		if v.kind != "" {
+			if v.kind == "id" {
+				it.idWidth = v.n
+			}
+			break
+		}
+	}
+
+	// the widest key, {id} is not truncated to its width
+	for _, v := range it.parts {
+		switch v.kind {
+
+		case "":
+			it.keySize += len(v.text)
+
+		case "id":
+			if v.n < 20 {
+				it.keySize += 20
+			} else {
+				it.keySize += int(v.n)
+			}
+
+		case "mod":
+			it.keySize += len(strconv.FormatUint(v.n-1, 10))
+
+		case "hex":
+			it.keySize += int(v.n)
+		}
+	}
+
+	return it, nil
+}
+
+func (it *keyValueTemplateKeyFormat) key(i uint64, seq bool) []byte {
+
+	if !seq {
+		i = keyHash(i)
+	}
+
+	var bs []byte
+	for j, v := range it.parts {
+		switch v.kind {
+
+		case "":
+			bs = append(bs, v.text...)
+
+		case "id":
+			id := strconv.FormatUint(i, 10)
+			for k := len(id); k < int(v.n); k++ {
+				bs = append(bs, '0')
+			}
+			bs = append(bs, id...)
+
+		case "mod":
+			bs = strconv.AppendUint(bs, i%v.n, 10)
+
+		case "hex":
+			bs = append(bs, hexKey(i^uint64(j), int(v.n)+1)[:v.n]...)
+		}
+	}
+
+	return bs
+}
+
+func (it *keyValueTemplateKeyFormat) ordered(n uint64) bool {
+	if it.idWidth == 0 {
+		return false
+	}
+	// the ids below n have at most idWidth digits
+	return it.idWidth >= 20 || n <= pow10(it.idWidth)
+}
+func (it *keyValueTemplateKeyFormat) size() int    { return it.keySize }
+func (it *keyValueTemplateKeyFormat) name() string { return keyFormatNameTemplate }
+
+func pow10(n uint64) uint64 {
+	v := uint64(1)
+	for ; n > 0; n-- {
+		v *= 10
+	}
+	return v
+}
+
+// hashBytes returns size bytes generated from x.
+func hashBytes(x uint64, size int) []byte {
+
+	bs := make([]byte, (size+7)/8*8)
+	for j := 0; j < len(bs); j += 8 {
+		x = splitmix64(x)
+		binary.BigEndian.PutUint64(bs[j:], x)
+	}
+
+	return bs[:size]
+}
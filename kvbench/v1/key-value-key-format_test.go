@@ -0,0 +1,136 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestKeyValueTemplateKeyFormat(t *testing.T) {
+
+	for _, v := range []struct {
+		template string
+		err      bool
+		size     int
+		idWidth  uint64
+	}{
+		{"", true, 0, 0},
+		{"user/{id", true, 0, 0},
+		{"user/{name}", true, 0, 0},
+		{"user/{mod}", true, 0, 0},
+		{"user/{id:0}", true, 0, 0},
+		{"user/{id:x}", true, 0, 0},
+		{"user/{id}", false, 5 + 20, 20},
+		{"user/{id:4}", false, 5 + 20, 4},
+		{"user/{id:24}", false, 5 + 24, 24},
+		{"{mod:16}/{id:12}", false, 2 + 1 + 20, 0},
+		{"t/{id:12}/{hex:8}", false, 2 + 20 + 1 + 8, 12},
+		{"t/{mod:1000}", false, 2 + 3, 0},
+	} {
+
+		f, err := newKeyValueTemplateKeyFormat(v.template)
+		if v.err {
+			if err == nil {
+				t.Fatalf("template %q: no error", v.template)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("template %q: %s", v.template, err.Error())
+		}
+
+		if f.size() != v.size {
+			t.Fatalf("template %q: size %d, want %d", v.template, f.size(), v.size)
+		}
+		if f.idWidth != v.idWidth {
+			t.Fatalf("template %q: id width %d, want %d", v.template, f.idWidth, v.idWidth)
+		}
+	}
+}
+
+func TestKeyValueTemplateKeyFormatOrdered(t *testing.T) {
+
+	for _, v := range []struct {
+		template string
+		n        uint64
+		ordered  bool
+	}{
+		{"t/{id:4}", 9999, true},
+		{"t/{id:4}", 10000, true},
+		{"t/{id:4}", 10001, false},
+		{"t/{id:1}", 10, true},
+		{"t/{id:1}", 11, false},
+		{"t/{id}", math.MaxUint64, true},
+		{"t/{id:19}", 1e19, true},
+		{"t/{id:19}", 1e19 + 1, false},
+		{"t/{id:24}", math.MaxUint64, true},
+		{"t/{mod:4}/{id:4}", 10, false},
+		{"t/{hex:8}", 10, false},
+	} {
+
+		f, err := newKeyValueTemplateKeyFormat(v.template)
+		if err != nil {
+			t.Fatalf("template %q: %s", v.template, err.Error())
+		}
+
+		if f.ordered(v.n) != v.ordered {
+			t.Fatalf("template %q: ordered(%d) %v, want %v", v.template, v.n, !v.ordered, v.ordered)
+		}
+	}
+}
+
+func TestKeyValueTemplateKeyFormatKey(t *testing.T) {
+
+	f, err := newKeyValueTemplateKeyFormat("t/{id:4}/{mod:3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		i   uint64
+		key string
+	}{
+		{0, "t/0000/0"},
+		{42, "t/0042/0"},
+		{9999, "t/9999/0"},
+		{12345, "t/12345/0"}, // wider than {id:4}, not truncated
+	} {
+		if key := string(f.key(v.i, true)); key != v.key {
+			t.Fatalf("key %d: %q, want %q", v.i, key, v.key)
+		}
+		if n := len(f.key(v.i, true)); n > f.size() {
+			t.Fatalf("key %d: size %d over %d", v.i, n, f.size())
+		}
+	}
+
+	// the seq keys below the n of ordered(n) sort in index order
+	const n = 10000
+	if !f.ordered(n) {
+		t.Fatalf("ordered(%d) false", n)
+	}
+	prev := f.key(0, true)
+	for i := uint64(1); i < n; i++ {
+		key := f.key(i, true)
+		if bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("key %d %q sorts before key %d %q", i, key, i-1, prev)
+		}
+		prev = key
+	}
+	if key := f.key(n, true); bytes.Compare(prev, key) < 0 {
+		t.Fatalf("key %d %q sorts in order, ordered(%d) is wrong", n, key, n+1)
+	}
+}
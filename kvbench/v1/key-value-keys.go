@@ -0,0 +1,132 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// keyValueKeySpace maps a key index to a key, so a preloaded keyspace can be
+// addressed without holding every key in memory.
+type keyValueKeySpace struct {
+	format keyValueKeyFormat
+	seq    bool
+	num    int64    // keys visible to readers
+	next   int64    // keys allocated by inserts
+	sums   []uint64 // preloaded value checksums, see --verify
+}
+
+// keySpaceSeed is the seed of every keyspace, so the same keys are
+// preloaded by every run and --preload_skip can reuse them.
+const keySpaceSeed = 0x6c796e6b62656e63
+
+func newKeyValueKeySpace(format keyValueKeyFormat, seq bool) *keyValueKeySpace {
+	return &keyValueKeySpace{
+		format: format,
+		seq:    seq,
+	}
+}
+
+func (it *keyValueKeySpace) key(i int64) []byte {
+	return it.format.key(uint64(i), it.seq)
+}
+
+// hexKey returns a hex string of size characters generated from x.
+func hexKey(x uint64, size int) string {
+
+	if size < 2 {
+		return ""
+	}
+
+	bs := make([]byte, ((size/2)+7)/8*8)
+	for j := 0; j < len(bs); j += 8 {
+		x = splitmix64(x)
+		binary.BigEndian.PutUint64(bs[j:], x)
+	}
+
+	return hex.EncodeToString(bs[:size/2])
+}
+
+func (it *keyValueKeySpace) size() int64 {
+	return atomic.LoadInt64(&it.num)
+}
+
+func (it *keyValueKeySpace) sizeSet(n int64) {
+	atomic.StoreInt64(&it.num, n)
+	atomic.StoreInt64(&it.next, n)
+}
+
+// alloc reserves the index of a new key, which becomes visible to readers
+// by commit once it has been written.
+func (it *keyValueKeySpace) alloc() int64 {
+	return atomic.AddInt64(&it.next, 1) - 1
+}
+
+func (it *keyValueKeySpace) commit(i int64) {
+	for {
+		n := atomic.LoadInt64(&it.num)
+		if i < n || atomic.CompareAndSwapInt64(&it.num, n, i+1) {
+			return
+		}
+	}
+}
+
+// verifySetup keeps a checksum of the first n preloaded values.
+func (it *keyValueKeySpace) verifySetup(n int64) {
+	it.sums = make([]uint64, n)
+}
+
+func (it *keyValueKeySpace) sumSet(i int64, value []byte) {
+	if i >= 0 && i < int64(len(it.sums)) {
+		atomic.StoreUint64(&it.sums[i], valueChecksum(value))
+	}
+}
+
+// sumReset forgets the checksum of a key that is about to be changed, so
+// reads of it are no longer verified.
+func (it *keyValueKeySpace) sumReset(i int64) {
+	if i >= 0 && i < int64(len(it.sums)) {
+		atomic.StoreUint64(&it.sums[i], 0)
+	}
+}
+
+// verify reports false if the checksum of key i is known and value does
+// not match it.
+func (it *keyValueKeySpace) verify(i int64, value []byte) bool {
+	if i < 0 || i >= int64(len(it.sums)) {
+		return true
+	}
+	sum := atomic.LoadUint64(&it.sums[i])
+	return sum == 0 || sum == valueChecksum(value)
+}
+
+func valueChecksum(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	if v := h.Sum64(); v > 0 {
+		return v
+	}
+	return 1
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyValueOpRead   = 1
+	keyValueOpWrite  = 2
+	keyValueOpDelete = 3
+	keyValueOpInsert = 4
+	keyValueOpScan   = 5
+	keyValueOpRMW    = 6
+)
+
+var (
+	keyValueOpMap = map[string]int{
+		"read":              keyValueOpRead,
+		"write":             keyValueOpWrite,
+		"delete":            keyValueOpDelete,
+		"insert":            keyValueOpInsert,
+		"scan":              keyValueOpScan,
+		"read-modify-write": keyValueOpRMW,
+	}
+	keyValueOpNameMap = map[int]string{
+		keyValueOpRead:   "read",
+		keyValueOpWrite:  "write",
+		keyValueOpDelete: "delete",
+		keyValueOpInsert: "insert",
+		keyValueOpScan:   "scan",
+		keyValueOpRMW:    "read-modify-write",
+	}
+)
+
+type keyValueOp struct {
+	kind     int
+	idx      int64
+	key      []byte
+	value    []byte
+	limit    int
+	end      []byte
+	num      int   // keys scanned
+	mismatch bool  // read value failed --verify
+	at       int64 // microseconds after the run start, if timed
+	timed    bool  // start at the time of at, see --trace_timed
+}
+
+type keyValueOpRatio struct {
+	kind   int
+	weight int64
+}
+
+type keyValueOpRatios []*keyValueOpRatio
+
+func keyValueOpName(kind int) string {
+	if s, ok := keyValueOpNameMap[kind]; ok {
+		return s
+	}
+	return ""
+}
+
+// newKeyValueOpRatios parses a ratio setting such as "read:70,write:30".
+func newKeyValueOpRatios(s string) (keyValueOpRatios, error) {
+
+	var (
+		ls = keyValueOpRatios{}
+		ta = int64(0)
+	)
+
+	for _, v := range strings.Split(s, ",") {
+
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		kv := strings.Split(v, ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid op ratio %q", v)
+		}
+
+		kind, ok := keyValueOpMap[strings.TrimSpace(kv[0])]
+		if !ok {
+			return nil, fmt.Errorf("invalid op ratio %q, unknown op", v)
+		}
+
+		w, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid op ratio %q, bad weight", v)
+		}
+
+		if ls.has(kind) {
+			return nil, fmt.Errorf("invalid op ratio %q, duplicate op", v)
+		}
+
+		if w > 0 {
+			ls = append(ls, &keyValueOpRatio{
+				kind:   kind,
+				weight: w,
+			})
+			ta += w
+		}
+	}
+
+	if ta < 1 {
+		return nil, errors.New("invalid op ratio, no weight found")
+	}
+
+	return ls, nil
+}
+
+func (it keyValueOpRatios) has(kind int) bool {
+	for _, v := range it {
+		if v.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (it keyValueOpRatios) next(r *mrand.Rand) int {
+	ta := int64(0)
+	for _, v := range it {
+		ta += v.weight
+	}
+	n := r.Int63n(ta)
+	for _, v := range it {
+		if n < v.weight {
+			return v.kind
+		}
+		n -= v.weight
+	}
+	return it[len(it)-1].kind
+}
+
+// name returns the ratio in a form usable as a dataset attr, e.g. read70-write30.
+func (it keyValueOpRatios) name() string {
+	ar := []string{}
+	for _, v := range it {
+		ar = append(ar, fmt.Sprintf("%s%d", keyValueOpName(v.kind), v.weight))
+	}
+	return strings.Join(ar, "-")
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"testing"
+)
+
+func TestKeyValueOpRatios(t *testing.T) {
+
+	for _, v := range []struct {
+		ratio string
+		err   bool
+		name  string
+	}{
+		{"read:50,write:50", false, "read50-write50"},
+		{" read : 70 , write : 30 ,", false, "read70-write30"},
+		{"read:95,insert:5,delete:0", false, "read95-insert5"},
+		{"scan:1,read-modify-write:3", false, "scan1-read-modify-write3"},
+		{"", true, ""},
+		{"read", true, ""},
+		{"read:1:2", true, ""},
+		{"get:50", true, ""},
+		{"read:x", true, ""},
+		{"read:-1,write:1", true, ""},
+		{"read:1,read:2", true, ""},
+		{"read:0,write:0", true, ""},
+	} {
+
+		ls, err := newKeyValueOpRatios(v.ratio)
+		if v.err {
+			if err == nil {
+				t.Fatalf("ratio %q: no error", v.ratio)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ratio %q: %s", v.ratio, err.Error())
+		}
+
+		if ls.name() != v.name {
+			t.Fatalf("ratio %q: name %s, want %s", v.ratio, ls.name(), v.name)
+		}
+	}
+}
+
+func TestKeyValueOpRatiosNext(t *testing.T) {
+
+	ls, err := newKeyValueOpRatios("read:70,write:30,delete:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		r      = newRand(1, randStreamOps)
+		counts = map[int]int{}
+		n      = 100000
+	)
+	for i := 0; i < n; i++ {
+		counts[ls.next(r)]++
+	}
+
+	if counts[keyValueOpDelete] != 0 {
+		t.Fatalf("%d deletes of weight 0", counts[keyValueOpDelete])
+	}
+	if v := counts[keyValueOpRead] * 100 / n; v < 69 || v > 71 {
+		t.Fatalf("%d%% reads, want 70%%", v)
+	}
+	if counts[keyValueOpRead]+counts[keyValueOpWrite] != n {
+		t.Fatalf("ops %v, want reads and writes only", counts)
+	}
+}
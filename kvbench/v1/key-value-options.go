@@ -0,0 +1,326 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hooto/hflag4g/hflag"
+
+	"github.com/hooto/hchart/v2/hcapi"
+)
+
+// KeyValueBenchOptions holds the settings of a KeyValueBench, one field per
+// command line flag of the same name. Start from DefaultKeyValueBenchOptions
+// and change the fields of interest.
+//
+// An out of range option or an unknown bench type is an error, and the
+// errors of all the options are returned at once, unless AllowClamp is set:
+// then the option is clamped to its range or the type dropped, and the
+// datasets record the clamped options.
+type KeyValueBenchOptions struct {
+	BenchTypes         []string `json:"bench_types"` // rand-write, rand-read, mixed, ...
+	Time               int64    `json:"time"`        // seconds
+	Warmup             int64    `json:"warmup"`      // seconds
+	Seed               int64    `json:"seed"`        // of the client generators, 0 picks one from the clock
+	ClientNum          int64    `json:"client_num"`
+	KeySize            int      `json:"key_size"`
+	KeyFormat          string   `json:"key_format"`
+	KeyPrefix          string   `json:"key_prefix"`
+	KeyTemplate        string   `json:"key_template"`
+	ValueSize          int      `json:"value_size"`
+	ValueSizeMin       int64    `json:"value_size_min"`  // 0 means ValueSize
+	ValueSizeMax       int64    `json:"value_size_max"`  // 0 means ValueSize
+	ValueSizeDist      string   `json:"value_size_dist"` // empty means histogram if hist is set, uniform if min or max is, else fixed
+	ValueSizeHist      string   `json:"value_size_hist"`
+	ValueCompressRatio float64  `json:"value_compress_ratio"`
+	LatencyMin         int64    `json:"latency_min"` // microseconds
+	LatencyMax         int64    `json:"latency_max"` // microseconds
+	MixedRatio         string   `json:"mixed_ratio"`
+	KeyDist            string   `json:"key_dist"`
+	KeyDistTheta       float64  `json:"key_dist_theta"`
+	KeyDistHotOps      int64    `json:"key_dist_hot_ops"`  // percent of ops
+	KeyDistHotKeys     int64    `json:"key_dist_hot_keys"` // percent of keys
+	ScanLength         int      `json:"scan_length"`
+	Rate               int64    `json:"rate"`        // ops per second, 0 means closed-loop
+	CoInterval         int64    `json:"co_interval"` // microseconds
+	OpTimeout          int64    `json:"op_timeout"`  // milliseconds, 0 means no timeout
+	Verify             bool     `json:"verify"`
+	PreloadKeys        int64    `json:"preload_keys"`
+	PreloadClients     int64    `json:"preload_clients"`
+	PreloadSkip        bool     `json:"preload_skip"`
+	TraceFile          string   `json:"trace_file"`
+	TraceTimed         bool     `json:"trace_timed"`
+	TraceRecord        string   `json:"trace_record"`
+	Saturation         string   `json:"saturation"`       // client or rate, empty disables the search
+	SaturationStart    int64    `json:"saturation_start"` // 0 means the default of the mode
+	SaturationStep     int64    `json:"saturation_step"`  // 0 doubles the load at each step
+	SaturationMax      int64    `json:"saturation_max"`   // 0 means the default of the mode
+	SaturationTime     int64    `json:"saturation_time"`  // seconds
+	SloP99             int64    `json:"slo_p99"`          // microseconds
+	SloErrorRate       float64  `json:"slo_error_rate"`
+	DataFile           string   `json:"data_file"`
+	DataName           string   `json:"data_name"`
+	Attrs              []string `json:"attrs"` // extra attributes of the datasets
+	AllowClamp         bool     `json:"allow_clamp"`
+}
+
+// DefaultKeyValueBenchOptions returns the options used when no flag is set.
+func DefaultKeyValueBenchOptions() *KeyValueBenchOptions {
+	return &KeyValueBenchOptions{
+		Time:               10,
+		ClientNum:          1,
+		KeySize:            40,
+		KeyFormat:          keyFormatNameHex,
+		ValueSize:          1 * 1024,
+		ValueCompressRatio: 0.5,
+		LatencyMin:         10,
+		LatencyMax:         100e3,
+		MixedRatio:         "read:50,write:50",
+		KeyDistTheta:       keyDistZipfianTheta,
+		KeyDistHotOps:      keyDistHotspotOps,
+		KeyDistHotKeys:     keyDistHotspotKeys,
+		ScanLength:         100,
+		PreloadKeys:        preloadKeysDefault,
+		PreloadClients:     1,
+		SaturationTime:     10,
+		SloP99:             10e3,
+		SloErrorRate:       1.0,
+		DataFile:           "lynkbench.json",
+	}
+}
+
+// keyValueBenchFlagOptions returns the default options overridden by the
+// command line flags.
+func keyValueBenchFlagOptions() (*KeyValueBenchOptions, error) {
+
+	o := DefaultKeyValueBenchOptions()
+
+	if v, ok := hflag.ValueOK("bench_types"); ok {
+		o.BenchTypes = strings.Split(v.String(), ",")
+	}
+
+	flagInt64("time", &o.Time)
+	flagInt64("warmup", &o.Warmup)
+	flagInt64("client_num", &o.ClientNum)
+	flagInt("key_size", &o.KeySize)
+	flagString("key_format", &o.KeyFormat)
+	flagString("key_prefix", &o.KeyPrefix)
+	flagString("key_template", &o.KeyTemplate)
+	flagInt("value_size", &o.ValueSize)
+	flagInt64("value_size_min", &o.ValueSizeMin)
+	flagInt64("value_size_max", &o.ValueSizeMax)
+	flagString("value_size_dist", &o.ValueSizeDist)
+	flagString("value_size_hist", &o.ValueSizeHist)
+	flagInt64("latency_min", &o.LatencyMin)
+	flagInt64("latency_max", &o.LatencyMax)
+	flagString("mixed_ratio", &o.MixedRatio)
+	flagString("key_dist", &o.KeyDist)
+	flagInt64("key_dist_hot_ops", &o.KeyDistHotOps)
+	flagInt64("key_dist_hot_keys", &o.KeyDistHotKeys)
+	flagInt("scan_length", &o.ScanLength)
+	flagInt64("rate", &o.Rate)
+	flagInt64("co_interval", &o.CoInterval)
+	flagInt64("op_timeout", &o.OpTimeout)
+	flagBool("verify", &o.Verify)
+	flagInt64("preload_keys", &o.PreloadKeys)
+	flagInt64("preload_clients", &o.PreloadClients)
+	flagBool("preload_skip", &o.PreloadSkip)
+	flagString("trace_file", &o.TraceFile)
+	flagBool("trace_timed", &o.TraceTimed)
+	flagString("trace_record", &o.TraceRecord)
+	flagString("saturation", &o.Saturation)
+	flagInt64("saturation_start", &o.SaturationStart)
+	flagInt64("saturation_step", &o.SaturationStep)
+	flagInt64("saturation_max", &o.SaturationMax)
+	flagInt64("saturation_time", &o.SaturationTime)
+	flagInt64("slo_p99", &o.SloP99)
+	flagString("data_name", &o.DataName)
+	flagBool("allow_clamp", &o.AllowClamp)
+
+	var errs []error
+
+	if v, ok := hflag.ValueOK("seed"); ok {
+		seed, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			errs = append(errs, errors.New("invalid --seed"))
+		} else {
+			o.Seed = seed
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		f    *float64
+	}{
+		{"value_compress_ratio", &o.ValueCompressRatio},
+		{"key_dist_theta", &o.KeyDistTheta},
+		{"slo_error_rate", &o.SloErrorRate},
+	} {
+		errs = append(errs, flagFloat64(v.name, v.f))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func flagString(name string, s *string) {
+	if v, ok := hflag.ValueOK(name); ok {
+		*s = v.String()
+	}
+}
+
+func flagBool(name string, b *bool) {
+	if _, ok := hflag.ValueOK(name); ok {
+		*b = true
+	}
+}
+
+func flagInt(name string, n *int) {
+	if v, ok := hflag.ValueOK(name); ok {
+		*n = v.Int()
+	}
+}
+
+func flagInt64(name string, n *int64) {
+	if v, ok := hflag.ValueOK(name); ok {
+		*n = v.Int64()
+	}
+}
+
+func flagFloat64(name string, f *float64) error {
+	if v, ok := hflag.ValueOK(name); ok {
+		f2, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return fmt.Errorf("invalid --%s", name)
+		}
+		*f = f2
+	}
+	return nil
+}
+
+// benchTypes returns the bench types of names, which may not be empty,
+// or an error for each unknown name.
+func (it *keyValueBenchOptions) benchTypes(names []string) ([]uint64, error) {
+
+	var (
+		ta      = uint64(0)
+		ts      = []uint64{}
+		dropped = false
+		errs    []error
+	)
+
+	for _, name := range names {
+
+		typ := benchType(name)
+		if typ == 0 {
+			if !it.allowClamp {
+				errs = append(errs, fmt.Errorf("invalid --bench_types, unknown type %q", name))
+				continue
+			}
+			fmt.Printf("Bench type %q unknown, dropped\n", name)
+			dropped = true
+			continue
+		}
+
+		if !uint64Allow(ta, typ) {
+			ts = append(ts, typ)
+			ta = ta | typ
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if dropped {
+		it.clamped = append(it.clamped, "bench_types")
+	}
+
+	if len(ts) < 1 {
+		return nil, errors.New("no --bench_types found")
+	}
+
+	return ts, nil
+}
+
+// clampInt64 checks that the option name is in [min, max], or clamps it to
+// the range with allowClamp.
+func (it *keyValueBenchOptions) clampInt64(name string, v *int64, min, max int64) error {
+
+	if *v >= min && *v <= max {
+		return nil
+	}
+
+	if !it.allowClamp {
+		if max == math.MaxInt64 {
+			return fmt.Errorf("invalid --%s %d, must be >= %d", name, *v, min)
+		}
+		return fmt.Errorf("invalid --%s %d, must be in [%d, %d]", name, *v, min, max)
+	}
+
+	v2 := min
+	if *v > max {
+		v2 = max
+	}
+	fmt.Printf("Bench option --%s %d clamped to %d\n", name, *v, v2)
+
+	*v = v2
+	it.clamped = append(it.clamped, fmt.Sprintf("%s=%d", name, v2))
+
+	return nil
+}
+
+func (it *keyValueBenchOptions) clampInt(name string, v *int, min, max int) error {
+	v2 := int64(*v)
+	err := it.clampInt64(name, &v2, int64(min), int64(max))
+	*v = int(v2)
+	return err
+}
+
+// datasetNew returns a dataset of a run of typ with these options, which
+// records the settings the run used, and attrs.
+func (it *keyValueBenchOptions) datasetNew(typ uint64, partial bool, attrs ...string) *hcapi.DataItem {
+
+	ds := hcapi.NewDataItem(it.dataName)
+	ds.AttrSet(benchTypeName(typ))
+	for _, av := range attrs {
+		ds.AttrSet(av)
+	}
+	ds.AttrSet(fmt.Sprintf("key-value-size:%d-%s", it.keySize, it.valueSizes.name()))
+	ds.AttrSet("key-format:" + it.keyFormat.name())
+	ds.AttrSet(fmt.Sprintf("value-compress-ratio:%g", it.valueCompress))
+	ds.AttrSet(fmt.Sprintf("value-compress-achieved:%g", it.valueCompressed))
+	ds.AttrSet(fmt.Sprintf("seed:%d", it.seed))
+	ds.AttrSet(fmt.Sprintf("time:%d", it.timeLen))
+	for _, av := range it.clamped {
+		ds.AttrSet("clamped:" + av)
+	}
+	for _, av := range it.attrs {
+		ds.AttrSet(av)
+	}
+	if partial {
+		ds.AttrSet("partial")
+	}
+
+	return ds
+}
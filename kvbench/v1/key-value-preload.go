@@ -0,0 +1,154 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hooto/hchart/v2/hcapi"
+)
+
+const (
+	preloadKeysDefault = 1000000
+)
+
+type keyValuePreloadStatus struct {
+	ok     int64
+	err    int64
+	npsMap []*keyValueWriteUsageItem
+}
+
+// preload writes the first preloadKeys keys of ks with preloadClients
+// concurrent writers, unless --preload_skip is set, and records the load
+// throughput and errors as preload datasets.
+func (it *keyValueBenchItem) preload(fn KeyValueBenchWorker, ks *keyValueKeySpace) {
+
+	n := it.options.preloadKeys
+
+	ks.sizeSet(n)
+	it.keys = ks
+	it.attrs = append(it.attrs, fmt.Sprintf("preload-keys:%d", n))
+
+	if it.options.preloadSkip {
+		return
+	}
+
+	if it.options.verify {
+		ks.verifySetup(n)
+	}
+
+	var (
+		st   keyValuePreloadStatus
+		wg   sync.WaitGroup
+		done = make(chan bool)
+		gts  = time.Now()
+	)
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				st.npsSet(int64(time.Since(gts) / time.Second))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	st.npsSet(0)
+
+	// client c loads the keys c, c + clients, c + 2*clients, ... with its
+	// own generator, so the loaded values only depend on --seed
+	clients := it.options.preloadClients
+
+	for c := int64(0); c < clients; c++ {
+
+		q := fn
+		if len(it.workers) > 0 {
+			q = it.workers[int(c)%len(it.workers)]
+		}
+
+		wg.Add(1)
+		go func(q KeyValueBenchWorker, c int64) {
+			defer wg.Done()
+			r := newRand(it.options.seed, randStreamPreload+uint64(c))
+			for i := c; i < n && !it.stopped(); i += clients {
+				val := randValue(r, it.options.valueSizes.next(r), it.options.valueCompress)
+				ctx, opDone := it.options.opContext()
+				switch workerWrite(ctx, q, ks.key(i), val) {
+				case ResultOK:
+					ks.sumSet(i, val)
+					atomic.AddInt64(&st.ok, 1)
+				case ResultTimeout:
+					atomic.AddInt64(&st.err, 1)
+					// a store which does not return the timed out write
+					// is hung, the client leaves its keys unloaded
+					if !opWait(opDone, keyValueDrainTimeout) {
+						fmt.Printf("Preload client %d retired, a write is not done in %v\n",
+							c, keyValueDrainTimeout)
+						atomic.AddInt64(&st.err, (n-i-1)/clients)
+						return
+					}
+					continue
+				default:
+					atomic.AddInt64(&st.err, 1)
+				}
+				opDone()
+			}
+		}(q, c)
+	}
+
+	wg.Wait()
+	close(done)
+
+	tc := time.Since(gts)
+	st.npsSet(int64((tc + time.Second - 1) / time.Second))
+
+	fmt.Printf("Preload %d keys in %v, %d errors\n", n, tc, st.err)
+
+	it.preloadDatasetsSync(fn, &st)
+}
+
+func (it *keyValuePreloadStatus) npsSet(v int64) {
+	it.npsMap = append(it.npsMap, &keyValueWriteUsageItem{
+		time: v,
+		num:  atomic.LoadInt64(&it.ok) + atomic.LoadInt64(&it.err),
+	})
+}
+
+func (it *keyValueBenchItem) preloadDatasetsSync(fn KeyValueBenchWorker, st *keyValuePreloadStatus) {
+
+	attr := fmt.Sprintf("preload-clients:%d", it.options.preloadClients)
+
+	ds := it.datasetNew(fn, "preload-throughput", attr)
+	for _, v := range st.npsMap {
+		ds.Points = append(ds.Points, &hcapi.DataPoint{
+			X: float64(v.time),
+			Y: float64(v.num),
+		})
+	}
+	it.datasets.Set(ds)
+
+	ds = it.datasetNew(fn, "preload-errors", attr)
+	ds.Points = append(ds.Points, &hcapi.DataPoint{
+		Y: float64(st.err),
+	})
+	it.datasets.Set(ds)
+}
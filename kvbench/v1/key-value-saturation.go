@@ -0,0 +1,201 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/hooto/hchart/v2/hcapi"
+)
+
+const (
+	saturationClient = "client"
+	saturationRate   = "rate"
+)
+
+// saturationSetup enables the saturation search, which steps the offered
+// load (client number or target rate) upward until the latency or error
+// rate SLO is violated.
+func (it *keyValueBenchOptions) saturationSetup(o *KeyValueBenchOptions) error {
+
+	it.saturation = o.Saturation
+	it.saturationStart = o.SaturationStart
+	it.saturationStep = o.SaturationStep
+	it.saturationMax = o.SaturationMax
+	it.saturationTime = o.SaturationTime
+	it.sloP99 = o.SloP99
+	it.sloErrorRate = o.SloErrorRate
+
+	// the default start and max, and the limit of the load
+	var start, max, limit int64
+
+	switch it.saturation {
+
+	case saturationClient:
+		start, max, limit = 1, 10000, 10000
+
+	case saturationRate:
+		start, max, limit = 1000, 10000000, math.MaxInt64
+
+	default:
+		return errors.New("invalid --saturation, must be client or rate")
+	}
+
+	var (
+		errs     []error
+		startErr error
+	)
+
+	// the range of saturation_max depends on a valid saturation_start
+	if it.saturationStart == 0 {
+		it.saturationStart = start
+	} else {
+		startErr = it.clampInt64("saturation_start", &it.saturationStart, 1, limit)
+		errs = append(errs, startErr)
+	}
+
+	// 0 doubles the load at each step
+	errs = append(errs, it.clampInt64("saturation_step", &it.saturationStep, 0, math.MaxInt64))
+
+	if it.saturationMax == 0 {
+		it.saturationMax = max
+	}
+	if startErr == nil {
+		errs = append(errs, it.clampInt64("saturation_max", &it.saturationMax, it.saturationStart, limit))
+	}
+
+	errs = append(errs, it.clampInt64("saturation_time", &it.saturationTime, 10, 600))
+
+	if it.sloP99 < 1 {
+		errs = append(errs, errors.New("invalid --slo_p99"))
+	}
+
+	if it.sloErrorRate < 0 || it.sloErrorRate > 100 {
+		errs = append(errs, errors.New("invalid --slo_error_rate, must be in [0, 100]"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (it *keyValueBenchOptions) saturationNext(load int64) int64 {
+	if it.saturationStep > 0 {
+		return load + it.saturationStep
+	}
+	return load * 2
+}
+
+func (it *KeyValueBench) runSaturation(fn KeyValueBenchWorker,
+	typ uint64, ls *hcapi.DataList) error {
+
+	var (
+		curve    = []*hcapi.DataPoint{}
+		attrs    []string
+		passLoad = int64(0)
+		passNps  = float64(0)
+		partial  = false
+	)
+
+	for load := it.options.saturationStart; load <= it.options.saturationMax; load = it.options.saturationNext(load) {
+
+		opts := *it.options
+		opts.timeLen = it.options.saturationTime
+		if it.options.saturation == saturationClient {
+			opts.clientNum = load
+		} else {
+			opts.rate = load
+		}
+
+		benchItem, err := it.runItem(fn, &opts, typ)
+		if err != nil {
+			return err
+		}
+
+		if err := it.datasetsSave(ls, benchItem.datasets.Items); err != nil {
+			return err
+		}
+		attrs = benchItem.workerAttrs
+		partial = benchItem.interrupted()
+
+		if partial {
+			break
+		}
+
+		if err := benchItem.verifyError(); err != nil {
+			return err
+		}
+
+		var (
+			st      = benchItem.status
+			num     = st.num()
+			p99     = st.latencyHist.percentile(99)
+			errRate = float64(0)
+		)
+		if num > 0 {
+			errRate = float64(100*st.err) / float64(num)
+		}
+
+		curve = append(curve, &hcapi.DataPoint{
+			X: float64Round(st.nps, 2),
+			Y: float64(p99),
+		})
+
+		fmt.Printf("Saturation %s %d: throughput %.2f, p99 %d us, error rate %.2f%%\n",
+			it.options.saturation, load, st.nps, p99, errRate)
+
+		if num < 1 || p99 > it.options.sloP99 || errRate > it.options.sloErrorRate {
+			break
+		}
+
+		passLoad, passNps = load, st.nps
+	}
+
+	ds := it.saturationDatasetNew(attrs, typ, partial, "saturation-curve")
+	ds.Points = curve
+
+	items := []*hcapi.DataItem{ds}
+	if passLoad > 0 {
+		passed := it.saturationDatasetNew(attrs, typ, partial, "saturation-max")
+		passed.Points = append(passed.Points, &hcapi.DataPoint{
+			X: float64(passLoad),
+			Y: float64Round(passNps, 2),
+		})
+		items = append(items, passed)
+	}
+
+	return it.datasetsSave(ls, items)
+}
+
+func (it *KeyValueBench) saturationDatasetNew(workerAttrs []string,
+	typ uint64, partial bool, attr string) *hcapi.DataItem {
+
+	// the time of a step
+	opts := *it.options
+	opts.timeLen = it.options.saturationTime
+
+	ds := opts.datasetNew(typ, partial, attr)
+	ds.AttrSet("saturation:" + it.options.saturation)
+	if it.options.saturation == saturationRate {
+		ds.AttrSet(fmt.Sprintf("client-num:%d", it.options.clientNum))
+	}
+	ds.AttrSet(fmt.Sprintf("slo-p99:%d", it.options.sloP99))
+	ds.AttrSet(fmt.Sprintf("slo-error-rate:%g", it.options.sloErrorRate))
+	for _, av := range workerAttrs {
+		ds.AttrSet(av)
+	}
+
+	return ds
+}
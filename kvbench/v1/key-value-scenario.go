@@ -0,0 +1,283 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyValueBenchScenario describes a suite of named workloads, read from a
+// YAML or JSON file such as
+//
+//	name: small-values
+//	data_file: small-values.json
+//	options:
+//	  time: 30
+//	  seed: 42
+//	workloads:
+//	  - name: load
+//	    options:
+//	      bench_types: rand-write,rand-read
+//	    matrix:
+//	      client_num: [1, 4, 16]
+//	      value_size: [128, 1024]
+//
+// Options and matrix keys are the command line flag names. Every workload
+// runs once per combination of its matrix values, with the options of the
+// scenario, then those of the workload and then the matrix values applied
+// on top of the base options. All runs write to the data file of the
+// scenario, with the scenario:<name>, workload:<name> and matrix attributes,
+// such as client-num:4.
+type KeyValueBenchScenario struct {
+	Name      string                   `json:"name"`
+	DataFile  string                   `json:"data_file"`
+	Options   map[string]interface{}   `json:"options"`
+	Workloads []*KeyValueBenchWorkload `json:"workloads"`
+}
+
+// KeyValueBenchWorkload is a named workload of a scenario.
+type KeyValueBenchWorkload struct {
+	Name    string                   `json:"name"`
+	Options map[string]interface{}   `json:"options"`
+	Matrix  map[string][]interface{} `json:"matrix"`
+}
+
+// LoadKeyValueBenchScenario reads a scenario file, which is YAML if its
+// name ends with .yaml or .yml, and JSON otherwise.
+func LoadKeyValueBenchScenario(path string) (*KeyValueBenchScenario, error) {
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+
+	case ".yaml", ".yml":
+		var obj interface{}
+		if err := yaml.Unmarshal(bs, &obj); err != nil {
+			return nil, fmt.Errorf("scenario %s: %s", path, err.Error())
+		}
+		if bs, err = json.Marshal(obj); err != nil {
+			return nil, fmt.Errorf("scenario %s: %s", path, err.Error())
+		}
+	}
+
+	var sc KeyValueBenchScenario
+	if err := jsonDecodeStrict(bs, &sc); err != nil {
+		return nil, fmt.Errorf("scenario %s: %s", path, err.Error())
+	}
+
+	return &sc, nil
+}
+
+// Expand returns the options of every run of the scenario, in the order of
+// the workloads and, within a workload, of the sorted matrix keys.
+func (it *KeyValueBenchScenario) Expand(base *KeyValueBenchOptions) ([]*KeyValueBenchOptions, error) {
+
+	if it.Name == "" {
+		return nil, errors.New("scenario: no name found")
+	}
+
+	if len(it.Workloads) < 1 {
+		return nil, errors.New("scenario: no workloads found")
+	}
+
+	var (
+		ls    []*KeyValueBenchOptions
+		names = map[string]bool{}
+	)
+
+	for _, w := range it.Workloads {
+
+		if w.Name == "" {
+			return nil, errors.New("scenario: workload without name")
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("scenario: duplicate workload %q", w.Name)
+		}
+		names[w.Name] = true
+
+		keys := []string{}
+		for k, vs := range w.Matrix {
+			if len(vs) < 1 {
+				return nil, fmt.Errorf("scenario: workload %s: no values of matrix %s", w.Name, k)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		// the index of each matrix key, the last key varies fastest
+		idx := make([]int, len(keys))
+
+		for {
+
+			opts, err := it.expand(base, w, keys, idx)
+			if err != nil {
+				return nil, fmt.Errorf("scenario: workload %s: %s", w.Name, err.Error())
+			}
+			ls = append(ls, opts)
+
+			i := len(keys) - 1
+			for ; i >= 0; i-- {
+				if idx[i]++; idx[i] < len(w.Matrix[keys[i]]) {
+					break
+				}
+				idx[i] = 0
+			}
+			if i < 0 {
+				break
+			}
+		}
+	}
+
+	return ls, nil
+}
+
+func (it *KeyValueBenchScenario) expand(base *KeyValueBenchOptions,
+	w *KeyValueBenchWorkload, keys []string, idx []int) (*KeyValueBenchOptions, error) {
+
+	opts := *base
+	opts.BenchTypes = append([]string{}, base.BenchTypes...)
+	opts.Attrs = append([]string{}, base.Attrs...)
+
+	if err := keyValueBenchOptionsApply(&opts, it.Options); err != nil {
+		return nil, err
+	}
+
+	if err := keyValueBenchOptionsApply(&opts, w.Options); err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	for i, k := range keys {
+		m[k] = w.Matrix[k][idx[i]]
+	}
+	if err := keyValueBenchOptionsApply(&opts, m); err != nil {
+		return nil, err
+	}
+
+	if opts.DataFile != base.DataFile {
+		return nil, errors.New("data_file can only be set at the top of the scenario")
+	}
+	if opts.TraceRecord != base.TraceRecord {
+		return nil, errors.New("trace_record can not be set in a scenario")
+	}
+
+	if it.DataFile != "" {
+		opts.DataFile = it.DataFile
+	}
+	opts.Attrs = append(opts.Attrs, "scenario:"+it.Name, "workload:"+w.Name)
+
+	// the runs of a workload only differ by their matrix values, which
+	// keep their datasets apart
+	for _, k := range keys {
+		opts.Attrs = append(opts.Attrs, keyValueMatrixAttr(k, m[k]))
+	}
+
+	return &opts, nil
+}
+
+// keyValueMatrixAttr returns the dataset attribute of the matrix value v of
+// the option k, such as client-num:4.
+func keyValueMatrixAttr(k string, v interface{}) string {
+
+	k = strings.Replace(k, "_", "-", -1)
+
+	if vs, ok := v.([]interface{}); ok {
+		ss := []string{}
+		for _, v2 := range vs {
+			ss = append(ss, fmt.Sprint(v2))
+		}
+		return k + ":" + strings.Join(ss, ",")
+	}
+
+	return fmt.Sprintf("%s:%v", k, v)
+}
+
+// keyValueBenchOptionsApply sets the options named by the keys of m, which
+// are the command line flag names.
+func keyValueBenchOptionsApply(opts *KeyValueBenchOptions, m map[string]interface{}) error {
+
+	if len(m) < 1 {
+		return nil
+	}
+
+	// bench_types may be given as "rand-write,rand-read" like the flag
+	if v, ok := m["bench_types"].(string); ok {
+		m2 := map[string]interface{}{}
+		for k, v2 := range m {
+			m2[k] = v2
+		}
+		m2["bench_types"] = strings.Split(v, ",")
+		m = m2
+	}
+
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return jsonDecodeStrict(bs, opts)
+}
+
+// jsonDecodeStrict decodes bs into obj, rejecting unknown fields. Numbers
+// decoded into an interface{} are kept as json.Number, so a large seed is
+// not rounded to a float64 on its way to the options.
+func jsonDecodeStrict(bs []byte, obj interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	return dec.Decode(obj)
+}
+
+// NewKeyValueBenchWithScenario returns a bench running every run of the
+// scenario sc, with base as the options the scenario is applied to.
+func NewKeyValueBenchWithScenario(sc *KeyValueBenchScenario, base *KeyValueBenchOptions) (*KeyValueBench, error) {
+
+	if base == nil {
+		base = DefaultKeyValueBenchOptions()
+	}
+
+	ls, err := sc.Expand(base)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &KeyValueBench{}
+
+	for i, opts := range ls {
+		options, err := newKeyValueBenchOptions(opts)
+		if err != nil {
+			return nil, fmt.Errorf("scenario: run %d (%s): %s",
+				i+1, strings.Join(opts.Attrs, " "), err.Error())
+		}
+		it.runs = append(it.runs, options)
+	}
+	it.options = it.runs[0]
+
+	fmt.Printf("Bench scenario %s, %d runs\n", sc.Name, len(it.runs))
+
+	return it, nil
+}
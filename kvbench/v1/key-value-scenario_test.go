@@ -0,0 +1,204 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueBenchScenarioLoad(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for _, v := range []struct {
+		name string
+		body string
+		err  bool
+	}{
+		{"s.json", `{"name":"s","options":{"seed":9007199254740993},
+			"workloads":[{"name":"w","matrix":{"seed":[9007199254740995]}}]}`, false},
+		{"s.yaml", "name: s\noptions:\n  seed: 9007199254740993\n" +
+			"workloads:\n  - name: w\n    matrix:\n      seed: [9007199254740995]\n", false},
+		{"s.yml", "name: s\nworkloads:\n  - name: w\n    seeds: [1]\n", true},
+		{"u.json", `{"name":"s","workloads":[{"name":"w"}],"unknown":1}`, true},
+		{"e.json", `{"name":`, true},
+	} {
+
+		path := filepath.Join(dir, v.name)
+		if err := os.WriteFile(path, []byte(v.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		sc, err := LoadKeyValueBenchScenario(path)
+		if v.err {
+			if err == nil {
+				t.Fatalf("scenario %s: no error", v.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("scenario %s: %s", v.name, err.Error())
+		}
+
+		ls, err := sc.Expand(DefaultKeyValueBenchOptions())
+		if err != nil {
+			t.Fatalf("scenario %s: %s", v.name, err.Error())
+		}
+
+		// a seed above 2^53 is not rounded to a float64
+		if len(ls) != 1 || ls[0].Seed != 9007199254740995 {
+			t.Fatalf("scenario %s: seed %d, want 9007199254740995", v.name, ls[0].Seed)
+		}
+	}
+}
+
+func TestKeyValueBenchScenarioExpand(t *testing.T) {
+
+	sc := &KeyValueBenchScenario{
+		Name:     "s",
+		DataFile: "s.json",
+		Options: map[string]interface{}{
+			"time": 30,
+		},
+		Workloads: []*KeyValueBenchWorkload{
+			{
+				Name: "load",
+				Options: map[string]interface{}{
+					"bench_types": "rand-write,rand-read",
+				},
+				Matrix: map[string][]interface{}{
+					"value_size": {128, 1024},
+					"client_num": {1, 4},
+				},
+			},
+			{
+				Name: "scan",
+				Options: map[string]interface{}{
+					"bench_types": []interface{}{"rand-scan"},
+					"time":        60,
+				},
+			},
+		},
+	}
+
+	base := DefaultKeyValueBenchOptions()
+	base.Attrs = []string{"host:a"}
+
+	ls, err := sc.Expand(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last sorted matrix key varies fastest
+	for i, v := range []struct {
+		benchTypes string
+		time       int64
+		clientNum  int64
+		valueSize  int
+		attrs      string
+	}{
+		{"rand-write,rand-read", 30, 1, 128, "host:a scenario:s workload:load client-num:1 value-size:128"},
+		{"rand-write,rand-read", 30, 1, 1024, "host:a scenario:s workload:load client-num:1 value-size:1024"},
+		{"rand-write,rand-read", 30, 4, 128, "host:a scenario:s workload:load client-num:4 value-size:128"},
+		{"rand-write,rand-read", 30, 4, 1024, "host:a scenario:s workload:load client-num:4 value-size:1024"},
+		{"rand-scan", 60, 1, base.ValueSize, "host:a scenario:s workload:scan"},
+	} {
+
+		if i >= len(ls) {
+			t.Fatalf("%d runs, want 5", len(ls))
+		}
+		o := ls[i]
+
+		if s := strings.Join(o.BenchTypes, ","); s != v.benchTypes {
+			t.Fatalf("run %d: bench types %s, want %s", i, s, v.benchTypes)
+		}
+		if o.Time != v.time || o.ClientNum != v.clientNum || o.ValueSize != v.valueSize {
+			t.Fatalf("run %d: time %d client_num %d value_size %d, want %d %d %d", i,
+				o.Time, o.ClientNum, o.ValueSize, v.time, v.clientNum, v.valueSize)
+		}
+		if s := strings.Join(o.Attrs, " "); s != v.attrs {
+			t.Fatalf("run %d: attrs %q, want %q", i, s, v.attrs)
+		}
+		if o.DataFile != "s.json" {
+			t.Fatalf("run %d: data file %s, want s.json", i, o.DataFile)
+		}
+	}
+
+	if len(ls) != 5 {
+		t.Fatalf("%d runs, want 5", len(ls))
+	}
+
+	// the runs do not share the slices of base
+	ls[0].Attrs[0] = "host:b"
+	if base.Attrs[0] != "host:a" || ls[1].Attrs[0] != "host:a" {
+		t.Fatal("runs share the attrs of base")
+	}
+}
+
+func TestKeyValueBenchScenarioExpandError(t *testing.T) {
+
+	for _, v := range []struct {
+		name string
+		sc   *KeyValueBenchScenario
+	}{
+		{"no name", &KeyValueBenchScenario{
+			Workloads: []*KeyValueBenchWorkload{{Name: "w"}},
+		}},
+		{"no workloads", &KeyValueBenchScenario{Name: "s"}},
+		{"workload without name", &KeyValueBenchScenario{
+			Name:      "s",
+			Workloads: []*KeyValueBenchWorkload{{}},
+		}},
+		{"duplicate workload", &KeyValueBenchScenario{
+			Name:      "s",
+			Workloads: []*KeyValueBenchWorkload{{Name: "w"}, {Name: "w"}},
+		}},
+		{"empty matrix", &KeyValueBenchScenario{
+			Name: "s",
+			Workloads: []*KeyValueBenchWorkload{{
+				Name:   "w",
+				Matrix: map[string][]interface{}{"client_num": {}},
+			}},
+		}},
+		{"unknown option", &KeyValueBenchScenario{
+			Name: "s",
+			Workloads: []*KeyValueBenchWorkload{{
+				Name:    "w",
+				Options: map[string]interface{}{"clients": 4},
+			}},
+		}},
+		{"data file in workload", &KeyValueBenchScenario{
+			Name: "s",
+			Workloads: []*KeyValueBenchWorkload{{
+				Name:    "w",
+				Options: map[string]interface{}{"data_file": "w.json"},
+			}},
+		}},
+		{"trace record", &KeyValueBenchScenario{
+			Name:    "s",
+			Options: map[string]interface{}{"trace_record": "s.trace"},
+			Workloads: []*KeyValueBenchWorkload{{
+				Name: "w",
+			}},
+		}},
+	} {
+		if _, err := v.sc.Expand(DefaultKeyValueBenchOptions()); err == nil {
+			t.Fatalf("%s: no error", v.name)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	valueSizeDistNameFixed     = "fixed"
+	valueSizeDistNameUniform   = "uniform"
+	valueSizeDistNameNormal    = "normal"
+	valueSizeDistNameLogNormal = "lognormal"
+	valueSizeDistNameHistogram = "histogram"
+
+	valueSizeLimit = 4 * 1024 * 1024 // 4 MB
+)
+
+// keyValueSizeChooser picks the size of the next generated value.
+type keyValueSizeChooser interface {
+	next(r *mrand.Rand) int
+	name() string
+}
+
+func newKeyValueSizeChooser(name string, size, min, max int, hist string) (keyValueSizeChooser, error) {
+
+	if min > max {
+		return nil, errors.New("invalid value size range, min > max")
+	}
+
+	if size < min || size > max {
+		size = (min + max) / 2
+	}
+
+	switch name {
+
+	case valueSizeDistNameFixed:
+		return &keyValueFixedSizeChooser{
+			size: size,
+		}, nil
+
+	case valueSizeDistNameUniform:
+		return &keyValueUniformSizeChooser{
+			min: min,
+			max: max,
+		}, nil
+
+	case valueSizeDistNameNormal:
+		// about 99.7% of the values fall in [min, max]
+		return &keyValueNormalSizeChooser{
+			mean:  size,
+			sigma: float64(max-min) / 6,
+			min:   min,
+			max:   max,
+		}, nil
+
+	case valueSizeDistNameLogNormal:
+		// median at size, long tail up to max
+		sigma := 0.0
+		if max > size {
+			sigma = math.Log(float64(max)/float64(size)) / 3
+		}
+		return &keyValueLogNormalSizeChooser{
+			median: size,
+			sigma:  sigma,
+			min:    min,
+			max:    max,
+		}, nil
+
+	case valueSizeDistNameHistogram:
+		// the histogram sizes are the range
+		return newKeyValueHistogramSizeChooser(hist, valueSizeLimit)
+	}
+
+	return nil, fmt.Errorf("invalid value size distribution %q", name)
+}
+
+type keyValueFixedSizeChooser struct {
+	size int
+}
+
+func (it *keyValueFixedSizeChooser) next(r *mrand.Rand) int {
+	return it.size
+}
+
+func (it *keyValueFixedSizeChooser) name() string {
+	return strconv.Itoa(it.size)
+}
+
+type keyValueUniformSizeChooser struct {
+	min, max int
+}
+
+func (it *keyValueUniformSizeChooser) next(r *mrand.Rand) int {
+	return it.min + r.Intn(it.max-it.min+1)
+}
+
+func (it *keyValueUniformSizeChooser) name() string {
+	return fmt.Sprintf("%s-%d-%d", valueSizeDistNameUniform, it.min, it.max)
+}
+
+type keyValueNormalSizeChooser struct {
+	mean     int
+	sigma    float64
+	min, max int
+}
+
+func (it *keyValueNormalSizeChooser) next(r *mrand.Rand) int {
+	return sizeClamp(int(r.NormFloat64()*it.sigma)+it.mean, it.min, it.max)
+}
+
+func (it *keyValueNormalSizeChooser) name() string {
+	return fmt.Sprintf("%s-%d-%d-%d", valueSizeDistNameNormal, it.mean, it.min, it.max)
+}
+
+type keyValueLogNormalSizeChooser struct {
+	median   int
+	sigma    float64
+	min, max int
+}
+
+func (it *keyValueLogNormalSizeChooser) next(r *mrand.Rand) int {
+	v := float64(it.median) * math.Exp(r.NormFloat64()*it.sigma)
+	return sizeClamp(int(v), it.min, it.max)
+}
+
+func (it *keyValueLogNormalSizeChooser) name() string {
+	return fmt.Sprintf("%s-%d-%d-%d", valueSizeDistNameLogNormal, it.median, it.min, it.max)
+}
+
+// keyValueHistogramSizeChooser picks sizes from an explicit histogram such
+// as "128:50,1024:40,65536:10" (size:weight).
+type keyValueHistogramSizeChooser struct {
+	sizes   []int
+	weights []int64
+	total   int64
+}
+
+func newKeyValueHistogramSizeChooser(s string, max int) (*keyValueHistogramSizeChooser, error) {
+
+	it := &keyValueHistogramSizeChooser{}
+
+	for _, v := range strings.Split(s, ",") {
+
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		kv := strings.Split(v, ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid value size histogram %q", v)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || size < 1 || size > max {
+			return nil, fmt.Errorf("invalid value size histogram %q, bad size", v)
+		}
+
+		w, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid value size histogram %q, bad weight", v)
+		}
+
+		if w > 0 {
+			it.sizes = append(it.sizes, size)
+			it.weights = append(it.weights, w)
+			it.total += w
+		}
+	}
+
+	if it.total < 1 {
+		return nil, errors.New("invalid value size histogram, no weight found")
+	}
+
+	return it, nil
+}
+
+func (it *keyValueHistogramSizeChooser) next(r *mrand.Rand) int {
+	n := r.Int63n(it.total)
+	for i, w := range it.weights {
+		if n < w {
+			return it.sizes[i]
+		}
+		n -= w
+	}
+	return it.sizes[len(it.sizes)-1]
+}
+
+func (it *keyValueHistogramSizeChooser) name() string {
+	ar := []string{valueSizeDistNameHistogram}
+	for i, size := range it.sizes {
+		ar = append(ar, fmt.Sprintf("%dx%d", size, it.weights[i]))
+	}
+	return strings.Join(ar, "-")
+}
+
+func sizeClamp(v, min, max int) int {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+	return v
+}
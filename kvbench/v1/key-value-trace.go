@@ -0,0 +1,400 @@
+// Copyright 2020 Eryx <evorui аt gmаil dοt cοm>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvbench
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyValueTraceReader reads a trace file, which holds one operation per line:
+//
+//	<op> <key> [<size> [<time>]]
+//
+// op is read, write, delete, insert, scan or read-modify-write, key is hex
+// encoded, size is the value size of a write or the limit of a scan, and
+// time is the start of the operation in microseconds since the start of the
+// trace. Empty lines and lines starting with # are skipped.
+type keyValueTraceReader struct {
+	path    string
+	fp      *os.File
+	scanner *bufio.Scanner
+	line    int
+	timed   bool
+	start   int64 // time of the first operation
+	err     error
+}
+
+func newKeyValueTraceReader(path string, timed bool) (*keyValueTraceReader, error) {
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	return &keyValueTraceReader{
+		path:    path,
+		fp:      fp,
+		scanner: scanner,
+		timed:   timed,
+		start:   -1,
+	}, nil
+}
+
+// next returns the next operation and its value size, or nil at the end of
+// the trace or on error.
+func (it *keyValueTraceReader) next() (*keyValueOp, int) {
+
+	for it.err == nil && it.scanner.Scan() {
+
+		it.line += 1
+
+		line := strings.TrimSpace(it.scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		op, size, err := it.parse(strings.Fields(line))
+		if err != nil {
+			it.err = fmt.Errorf("trace %s line %d: %s", it.path, it.line, err.Error())
+			return nil, 0
+		}
+
+		return op, size
+	}
+
+	if it.err == nil {
+		it.err = it.scanner.Err()
+	}
+
+	return nil, 0
+}
+
+func (it *keyValueTraceReader) parse(fields []string) (*keyValueOp, int, error) {
+
+	if len(fields) < 2 || len(fields) > 4 {
+		return nil, 0, fmt.Errorf("invalid operation, fields %d", len(fields))
+	}
+
+	kind, ok := keyValueOpMap[fields[0]]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid operation %q", fields[0])
+	}
+	if kind == keyValueOpInsert {
+		kind = keyValueOpWrite
+	}
+
+	key, err := hex.DecodeString(fields[1])
+	if err != nil || len(key) < 1 {
+		return nil, 0, fmt.Errorf("invalid key %q", fields[1])
+	}
+
+	op := &keyValueOp{
+		kind: kind,
+		key:  key,
+	}
+
+	size := 0
+	if len(fields) > 2 {
+		if size, err = strconv.Atoi(fields[2]); err != nil || size < 0 {
+			return nil, 0, fmt.Errorf("invalid size %q", fields[2])
+		}
+	}
+
+	if kind == keyValueOpScan {
+		op.limit = size
+	}
+
+	if it.timed {
+
+		if len(fields) < 4 {
+			return nil, 0, fmt.Errorf("no time found")
+		}
+
+		ts, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil || ts < 0 {
+			return nil, 0, fmt.Errorf("invalid time %q", fields[3])
+		}
+
+		if it.start < 0 {
+			it.start = ts
+		}
+		if ts < it.start {
+			ts = it.start
+		}
+
+		op.at = ts - it.start
+		op.timed = true
+	}
+
+	return op, size, nil
+}
+
+func (it *keyValueTraceReader) close() {
+	it.fp.Close()
+}
+
+// runTrace replays the operations of --trace_file, as fast as the clients
+// allow or, with --trace_timed, at their recorded times.
+func (it *keyValueBenchItem) runTrace(fn KeyValueBenchWorker) error {
+
+	tr, err := newKeyValueTraceReader(it.options.traceFile, it.options.traceTimed)
+	if err != nil {
+		return err
+	}
+	defer tr.close()
+
+	for kind := range keyValueOpNameMap {
+		if kind != keyValueOpInsert { // replayed as write
+			it.opStatus[kind] = newKeyValueBenchStatus(it.options)
+		}
+	}
+
+	replay := "fast"
+	if it.options.traceTimed {
+		replay = "timed"
+	}
+	it.attrs = append(it.attrs,
+		"trace:"+filepath.Base(it.options.traceFile), "trace-replay:"+replay)
+
+	it.runClients(fn, func(r *mrand.Rand) *keyValueOp {
+
+		op, size := tr.next()
+		if op == nil {
+			return nil
+		}
+
+		switch op.kind {
+		case keyValueOpWrite, keyValueOpRMW:
+			op.value = randValue(r, size, it.options.valueCompress)
+
+		case keyValueOpScan:
+			if op.limit < 1 {
+				op.limit = it.options.scanLength
+			}
+		}
+
+		return op
+	})
+
+	if tr.err != nil {
+		return tr.err
+	}
+
+	it.datasetsSync(fn)
+
+	return nil
+}
+
+// KeyValueBenchRecorder writes the operations of the workers it wraps to a
+// trace, which can be replayed by the trace bench type.
+type KeyValueBenchRecorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	start time.Time
+	err   error
+}
+
+// NewKeyValueBenchRecorder returns a recorder writing the trace to w. Flush
+// must be called once the bench is done.
+func NewKeyValueBenchRecorder(w io.Writer) *KeyValueBenchRecorder {
+	return &KeyValueBenchRecorder{
+		w:     bufio.NewWriterSize(w, 256*1024),
+		start: time.Now(),
+	}
+}
+
+func (it *KeyValueBenchRecorder) record(kind int, key []byte, size int) {
+
+	ts := time.Now()
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.err == nil {
+		_, it.err = fmt.Fprintf(it.w, "%s %x %d %d\n",
+			keyValueOpName(kind), key, size, ts.Sub(it.start)/time.Microsecond)
+	}
+}
+
+// Flush writes the buffered trace to the underlying writer, and returns the
+// first error of the trace.
+func (it *KeyValueBenchRecorder) Flush() error {
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.err == nil {
+		it.err = it.w.Flush()
+	}
+
+	return it.err
+}
+
+// Wrap returns a worker which records the operations of fn before calling
+// it. The worker implements the optional interfaces of fn, and only those,
+// so the runs fn does not support are refused as they are without the
+// recorder.
+func (it *KeyValueBenchRecorder) Wrap(fn KeyValueBenchWorker) KeyValueBenchWorker {
+
+	var (
+		w     = &keyValueRecorderWorker{KeyValueBenchWorker: fn, trace: it}
+		_, fr = fn.(KeyValueBenchValueReader)
+		_, fd = fn.(KeyValueBenchDeleter)
+		_, fs = fn.(KeyValueBenchScanner)
+	)
+
+	var (
+		r = keyValueRecorderValueReader{w}
+		d = keyValueRecorderDeleter{w}
+		s = keyValueRecorderScanner{w}
+	)
+
+	switch {
+	case fr && fd && fs:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderValueReader
+			keyValueRecorderDeleter
+			keyValueRecorderScanner
+		}{w, r, d, s}
+
+	case fr && fd:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderValueReader
+			keyValueRecorderDeleter
+		}{w, r, d}
+
+	case fr && fs:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderValueReader
+			keyValueRecorderScanner
+		}{w, r, s}
+
+	case fd && fs:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderDeleter
+			keyValueRecorderScanner
+		}{w, d, s}
+
+	case fr:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderValueReader
+		}{w, r}
+
+	case fd:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderDeleter
+		}{w, d}
+
+	case fs:
+		return &struct {
+			*keyValueRecorderWorker
+			keyValueRecorderScanner
+		}{w, s}
+	}
+
+	return w
+}
+
+// keyValueRecorderWorker records the writes and reads of the wrapped worker.
+// It is context aware whether the worker is or not, its calls are adapted
+// as the bench does for any worker.
+type keyValueRecorderWorker struct {
+	KeyValueBenchWorker
+	trace *KeyValueBenchRecorder
+}
+
+func (it *keyValueRecorderWorker) Write(key, value []byte) ResultStatus {
+	it.trace.record(keyValueOpWrite, key, len(value))
+	return it.KeyValueBenchWorker.Write(key, value)
+}
+
+func (it *keyValueRecorderWorker) Read(key []byte) ResultStatus {
+	it.trace.record(keyValueOpRead, key, 0)
+	return it.KeyValueBenchWorker.Read(key)
+}
+
+func (it *keyValueRecorderWorker) WriteContext(ctx context.Context, key, value []byte) ResultStatus {
+	it.trace.record(keyValueOpWrite, key, len(value))
+	return workerWrite(ctx, it.KeyValueBenchWorker, key, value)
+}
+
+func (it *keyValueRecorderWorker) ReadContext(ctx context.Context, key []byte) ResultStatus {
+	it.trace.record(keyValueOpRead, key, 0)
+	return workerRead(ctx, it.KeyValueBenchWorker, key)
+}
+
+// Close closes the wrapped worker if it is an io.Closer.
+func (it *keyValueRecorderWorker) Close() error {
+	if c, ok := it.KeyValueBenchWorker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type keyValueRecorderValueReader struct {
+	w *keyValueRecorderWorker
+}
+
+func (it keyValueRecorderValueReader) ReadValue(key []byte) ([]byte, ResultStatus) {
+	it.w.trace.record(keyValueOpRead, key, 0)
+	return workerReadValue(context.Background(), it.w.KeyValueBenchWorker, key)
+}
+
+type keyValueRecorderDeleter struct {
+	w *keyValueRecorderWorker
+}
+
+func (it keyValueRecorderDeleter) Delete(key []byte) ResultStatus {
+	return it.DeleteContext(context.Background(), key)
+}
+
+func (it keyValueRecorderDeleter) DeleteContext(ctx context.Context, key []byte) ResultStatus {
+	it.w.trace.record(keyValueOpDelete, key, 0)
+	return workerDelete(ctx, it.w.KeyValueBenchWorker, key)
+}
+
+type keyValueRecorderScanner struct {
+	w *keyValueRecorderWorker
+}
+
+func (it keyValueRecorderScanner) Scan(start, end []byte, limit int) (int, ResultStatus) {
+	return it.ScanContext(context.Background(), start, end, limit)
+}
+
+func (it keyValueRecorderScanner) ScanContext(ctx context.Context,
+	start, end []byte, limit int) (int, ResultStatus) {
+	it.w.trace.record(keyValueOpScan, start, limit)
+	return workerScan(ctx, it.w.KeyValueBenchWorker, start, end, limit)
+}
@@ -17,7 +17,12 @@ package kvbench
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hooto/hflag4g/hflag"
@@ -35,8 +40,28 @@ type KeyValueBenchWorker interface {
 	Clean() error
 }
 
-type keyValueItem struct {
-	Key, Value []byte
+// KeyValueBenchWorkerFactory creates the worker of one client, so every
+// client of a benchmark gets its own connection or handle. Workers that
+// implement io.Closer are closed when the benchmark is done.
+type KeyValueBenchWorkerFactory func(clientID int) (KeyValueBenchWorker, error)
+
+// KeyValueBenchDeleter is an optional interface of KeyValueBenchWorker,
+// required by benchmarks that delete keys.
+type KeyValueBenchDeleter interface {
+	Delete(key []byte) ResultStatus
+}
+
+// KeyValueBenchValueReader is an optional interface of KeyValueBenchWorker,
+// required by --verify to check the values returned by reads.
+type KeyValueBenchValueReader interface {
+	ReadValue(key []byte) ([]byte, ResultStatus)
+}
+
+// KeyValueBenchScanner is an optional interface of KeyValueBenchWorker,
+// required by benchmarks that scan ordered key ranges. An empty end key
+// means no upper bound. It returns the number of keys scanned.
+type KeyValueBenchScanner interface {
+	Scan(start, end []byte, limit int) (int, ResultStatus)
 }
 
 type keyValueWriteUsageItem struct {
@@ -45,108 +70,270 @@ type keyValueWriteUsageItem struct {
 }
 
 type keyValueBenchOptions struct {
-	types         []uint64
-	timeLen       int64 // seconds
-	timeStep      int64 // seconds
-	keySize       int
-	valueSize     int
-	valueSizeMin  int64
-	valueSizeMax  int64
-	clientNum     int64
-	latencyMin    int64 // microseconds
-	latencyMax    int64 // microseconds
-	latencyRanges []int64
-	dataFile      string
-	dataName      string
+	types           []uint64
+	timeLen         int64 // seconds
+	timeStep        int64 // seconds
+	warmup          int64 // seconds
+	keySize         int
+	keyFormat       keyValueKeyFormat
+	keyPrefix       string
+	keyTemplate     string
+	valueSize       int
+	valueSizeMin    int64
+	valueSizeMax    int64
+	valueSizeDist   string
+	valueSizes      keyValueSizeChooser
+	valueCompress   float64 // compressed / original size of values
+	valueCompressed float64 // achieved valueCompress of sample values
+	clientNum       int64
+	latencyMin      int64 // microseconds
+	latencyMax      int64 // microseconds
+	latencyRanges   []int64
+	opRatios        keyValueOpRatios
+	keyDist         string
+	keyDistTheta    float64
+	keyDistHotOps   int64 // percent of ops
+	keyDistHotKeys  int64 // percent of keys
+	scanLength      int
+	rate            int64 // ops per second, 0 means closed-loop
+	coInterval      int64 // microseconds
+	opTimeout       int64 // milliseconds, 0 means no timeout
+	verify          bool
+	preloadKeys     int64
+	preloadClients  int64
+	preloadSkip     bool
+	saturation      string
+	saturationStart int64
+	saturationStep  int64
+	saturationMax   int64
+	saturationTime  int64   // seconds
+	sloP99          int64   // microseconds
+	sloErrorRate    float64 // percent
+	seed            int64
+	traceFile       string
+	traceTimed      bool
+	traceRecord     string
+	dataFile        string
+	dataName        string
+	attrs           []string
+	allowClamp      bool
+	clamped         []string // options changed by allowClamp, as name=value
 }
 
+// ErrInterrupted is returned by Run once the bench is stopped by SIGINT or
+// SIGTERM, after the datasets of the interrupted run have been saved.
+var ErrInterrupted = errors.New("kvbench: interrupted")
+
 type KeyValueBench struct {
-	options *keyValueBenchOptions
-	items   []*keyValueBenchItem
+	options     *keyValueBenchOptions // of the current run
+	runs        []*keyValueBenchOptions
+	items       []*keyValueBenchItem
+	factory     KeyValueBenchWorkerFactory
+	mu          sync.Mutex
+	current     *keyValueBenchItem
+	interrupted bool
 }
 
+// NewKeyValueBench returns a bench configured by the command line flags,
+// or by the scenario file of --scenario with the flags as base options.
 func NewKeyValueBench() (*KeyValueBench, error) {
 
-	opts, err := newKeyValueBenchOptions()
+	opts, err := keyValueBenchFlagOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := hflag.ValueOK("scenario"); ok {
+		sc, err := LoadKeyValueBenchScenario(v.String())
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyValueBenchWithScenario(sc, opts)
+	}
+
+	return NewKeyValueBenchWithOptions(opts)
+}
+
+// NewKeyValueBenchWithOptions returns a bench configured by opts, which is
+// not retained, so a caller may change and reuse it for the next bench.
+func NewKeyValueBenchWithOptions(opts *KeyValueBenchOptions) (*KeyValueBench, error) {
+
+	options, err := newKeyValueBenchOptions(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	return &KeyValueBench{
-		options: opts,
+		options: options,
+		runs:    []*keyValueBenchOptions{options},
 	}, nil
 }
 
-func newKeyValueBenchOptions() (*keyValueBenchOptions, error) {
+func newKeyValueBenchOptions(o *KeyValueBenchOptions) (*keyValueBenchOptions, error) {
 
 	it := &keyValueBenchOptions{
-		types:        benchTypes(hflag.Value("bench_types").String()),
-		timeLen:      10, // 10 s
-		clientNum:    1,
-		keySize:      40,
-		valueSize:    1 * 1024, // 1 KB
-		valueSizeMin: 0,
-		valueSizeMax: 0,
-		latencyMin:   10,    // 10 us
-		latencyMax:   100e3, // 100 ms
-		dataFile:     "lynkbench.json",
-	}
-
-	if len(it.types) < 1 {
-		return nil, errors.New("no --bench_types found")
-	}
-
-	if v, ok := hflag.ValueOK("time"); ok {
-		if it.timeLen = v.Int64(); it.timeLen < 10 {
-			it.timeLen = 10
-		} else if it.timeLen > 600 {
-			it.timeLen = 600
+		timeLen:        o.Time,
+		warmup:         o.Warmup,
+		seed:           o.Seed,
+		clientNum:      o.ClientNum,
+		keySize:        o.KeySize,
+		keyPrefix:      o.KeyPrefix,
+		keyTemplate:    o.KeyTemplate,
+		valueSize:      o.ValueSize,
+		valueSizeMin:   o.ValueSizeMin,
+		valueSizeMax:   o.ValueSizeMax,
+		valueSizeDist:  o.ValueSizeDist,
+		valueCompress:  o.ValueCompressRatio,
+		latencyMin:     o.LatencyMin,
+		latencyMax:     o.LatencyMax,
+		keyDist:        o.KeyDist,
+		keyDistTheta:   o.KeyDistTheta,
+		keyDistHotOps:  o.KeyDistHotOps,
+		keyDistHotKeys: o.KeyDistHotKeys,
+		scanLength:     o.ScanLength,
+		rate:           o.Rate,
+		coInterval:     o.CoInterval,
+		opTimeout:      o.OpTimeout,
+		verify:         o.Verify,
+		preloadKeys:    o.PreloadKeys,
+		preloadClients: o.PreloadClients,
+		preloadSkip:    o.PreloadSkip,
+		traceFile:      o.TraceFile,
+		traceTimed:     o.TraceTimed,
+		traceRecord:    o.TraceRecord,
+		dataFile:       o.DataFile,
+		dataName:       o.DataName,
+		attrs:          append([]string{}, o.Attrs...),
+		allowClamp:     o.AllowClamp,
+	}
+
+	// the checks are independent, so all of the invalid options are
+	// reported at once
+	var errs []error
+
+	types, err := it.benchTypes(o.BenchTypes)
+	errs = append(errs, err)
+	it.types = types
+
+	errs = append(errs, it.clampInt64("time", &it.timeLen, 10, 600))
+
+	if it.seed == 0 {
+		it.seed = time.Now().UnixNano()
+	}
+
+	errs = append(errs, it.clampInt64("warmup", &it.warmup, 0, 600))
+	errs = append(errs, it.clampInt("key_size", &it.keySize, 8, 1024))
+
+	keyFormat := o.KeyFormat
+	if keyFormat == "" {
+		keyFormat = keyFormatNameHex
+	}
+	if format, err := newKeyValueKeyFormat(keyFormat, it); err != nil {
+		errs = append(errs, err)
+	} else {
+		it.keyFormat = format
+		it.keySize = format.size()
+	}
+
+	// the value size chooser is only built from valid sizes
+	sizeErrs := []error{it.clampInt("value_size", &it.valueSize, 1, valueSizeLimit)}
+
+	if it.valueSizeDist == "" {
+		if o.ValueSizeHist != "" {
+			it.valueSizeDist = valueSizeDistNameHistogram
+		} else if it.valueSizeMin != 0 || it.valueSizeMax != 0 {
+			it.valueSizeDist = valueSizeDistNameUniform
+		} else {
+			it.valueSizeDist = valueSizeDistNameFixed
 		}
 	}
 
-	if v, ok := hflag.ValueOK("key_size"); ok {
-		if it.keySize = v.Int(); it.keySize < 16 {
-			it.keySize = 16
-		} else if it.keySize > 100 {
-			it.keySize = 100
-		}
+	if it.valueSizeMin == 0 {
+		it.valueSizeMin = int64(it.valueSize)
+	} else {
+		sizeErrs = append(sizeErrs, it.clampInt64("value_size_min", &it.valueSizeMin, 1, valueSizeLimit))
 	}
 
-	if v, ok := hflag.ValueOK("value_size"); ok {
-		if it.valueSize = v.Int(); it.valueSize < 1 {
-			it.valueSize = 1
-		} else if it.valueSize > (4 * 1024 * 1024) {
-			it.valueSize = 4 * 1024 * 1024
-		}
+	if it.valueSizeMax == 0 {
+		it.valueSizeMax = int64(it.valueSize)
+	} else {
+		sizeErrs = append(sizeErrs, it.clampInt64("value_size_max", &it.valueSizeMax, 1, valueSizeLimit))
+	}
+
+	if err := errors.Join(sizeErrs...); err != nil {
+		errs = append(errs, err)
+	} else if sizes, err := newKeyValueSizeChooser(it.valueSizeDist, it.valueSize,
+		int(it.valueSizeMin), int(it.valueSizeMax), o.ValueSizeHist); err != nil {
+		errs = append(errs, err)
+	} else {
+		it.valueSizes = sizes
+	}
+
+	if it.valueCompress <= 0 || it.valueCompress > 1 {
+		errs = append(errs, errors.New("invalid --value_compress_ratio, must be in (0, 1]"))
+	}
+
+	// 1 us to 1 s, the range of latency_max depends on a valid latency_min
+	if err := it.clampInt64("latency_min", &it.latencyMin, 1, 1e6); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, it.clampInt64("latency_max", &it.latencyMax, it.latencyMin*10, math.MaxInt64))
+	}
+
+	errs = append(errs, it.clampInt64("client_num", &it.clientNum, 1, 10000))
+	errs = append(errs, it.clampInt64("rate", &it.rate, 0, math.MaxInt64))
+	errs = append(errs, it.clampInt64("co_interval", &it.coInterval, 0, math.MaxInt64))
+	errs = append(errs, it.clampInt64("op_timeout", &it.opTimeout, 0, math.MaxInt64))
+	errs = append(errs, it.clampInt64("preload_keys", &it.preloadKeys, 1, math.MaxInt64))
+	errs = append(errs, it.clampInt64("preload_clients", &it.preloadClients, 1, 10000))
+
+	if it.verify && it.preloadSkip {
+		errs = append(errs, errors.New("--verify can not be used with --preload_skip"))
 	}
 
-	if v, ok := hflag.ValueOK("latency_min"); ok {
-		if it.latencyMin = v.Int64(); it.latencyMin < 1 {
-			it.latencyMin = 1 // 1 us
-		} else if it.latencyMin > 1e6 {
-			it.latencyMin = 1e6 // 1 s
+	for _, typ := range it.types {
+		if typ == BenchTypeTrace && it.traceFile == "" {
+			errs = append(errs, errors.New("bench type trace requires --trace_file"))
 		}
 	}
 
-	if v, ok := hflag.ValueOK("latency_max"); ok {
-		it.latencyMax = v.Int64()
+	if o.Saturation != "" {
+		errs = append(errs, it.saturationSetup(o))
 	}
-	if (it.latencyMin * 10) > it.latencyMax {
-		it.latencyMax = it.latencyMin * 10
+
+	errs = append(errs, it.clampInt("scan_length", &it.scanLength, 1, 10000))
+
+	if it.keyDistTheta <= 0 || it.keyDistTheta >= 1 {
+		errs = append(errs, errors.New("invalid --key_dist_theta, must be in (0, 1)"))
+	}
+
+	if it.keyDistHotOps < 0 || it.keyDistHotOps > 100 {
+		errs = append(errs, errors.New("invalid --key_dist_hot_ops, must be in [0, 100]"))
 	}
 
-	if v, ok := hflag.ValueOK("client_num"); ok {
-		if it.clientNum = v.Int64(); it.clientNum < 1 {
-			it.clientNum = 1
-		} else if it.clientNum > 10000 {
-			it.clientNum = 10000
+	if it.keyDistHotKeys < 1 || it.keyDistHotKeys > 100 {
+		errs = append(errs, errors.New("invalid --key_dist_hot_keys, must be in [1, 100]"))
+	}
+
+	if ratios, err := newKeyValueOpRatios(o.MixedRatio); err != nil {
+		errs = append(errs, err)
+	} else {
+		it.opRatios = ratios
+	}
+
+	if it.keyDist != "" {
+		if _, err := newKeyValueKeyChooser(it.keyDist, it); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	if v, ok := hflag.ValueOK("data_name"); ok {
-		it.dataName = v.String()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
 
+	it.valueCompressed = randValueCompressRatio(
+		newRand(it.seed, randStreamSample), it.valueSizes, it.valueCompress)
+
 	// NPS
 	it.timeStep = int64(1)
 	/**
@@ -178,7 +365,30 @@ func newKeyValueBenchOptions() (*keyValueBenchOptions, error) {
 	return it, nil
 }
 
+// Run runs the benchmarks with fn shared by all clients, so fn must be
+// safe for concurrent use.
 func (it *KeyValueBench) Run(fn KeyValueBenchWorker) error {
+	it.factory = nil
+	return it.run(fn)
+}
+
+// RunWithFactory runs the benchmarks with a worker per client created by
+// factory. The worker of client 0 also cleans and preloads the data.
+func (it *KeyValueBench) RunWithFactory(factory KeyValueBenchWorkerFactory) error {
+
+	if factory == nil {
+		return errors.New("no worker factory found")
+	}
+
+	it.factory = factory
+	defer func() {
+		it.factory = nil
+	}()
+
+	return it.run(nil)
+}
+
+func (it *KeyValueBench) run(fn KeyValueBenchWorker) (err error) {
 
 	var (
 		ls hcapi.DataList
@@ -190,49 +400,203 @@ func (it *KeyValueBench) Run(fn KeyValueBenchWorker) error {
 		}
 	}
 
-	for _, typ := range it.options.types {
+	// the first signal stops the run and saves what it has done so far,
+	// the second one exits at once
+	var (
+		sigs    = make(chan os.Signal, 2)
+		sigDone = make(chan bool)
+	)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigs)
+		close(sigDone)
+	}()
+
+	go func() {
+		for n := 0; ; n++ {
+			select {
+			case sig := <-sigs:
+				if n > 0 {
+					os.Exit(1)
+				}
+				fmt.Printf("\nBench %s, saving partial results\n", sig)
+				it.interrupt()
+			case <-sigDone:
+				return
+			}
+		}
+	}()
 
-		benchItem := newkeyValueBenchItem(it.options)
-		benchItem.typ = typ
+	if it.options.traceRecord != "" {
 
-		var cio []float64
-		for {
-			cio, _ = ps_cpu.Percent(3e9, false)
+		fp, err := os.Create(it.options.traceRecord)
+		if err != nil {
+			return err
+		}
 
-			if (cio[0] / 10) < 1.0 {
-				break
+		// a run which is done but whose trace is lost fails
+		trace := NewKeyValueBenchRecorder(fp)
+		defer func() {
+			if err2 := trace.Flush(); err == nil {
+				err = err2
+			}
+			if err2 := fp.Close(); err == nil {
+				err = err2
+			}
+		}()
+
+		if factory := it.factory; factory != nil {
+			it.factory = func(clientID int) (KeyValueBenchWorker, error) {
+				fn, err := factory(clientID)
+				if err != nil || fn == nil {
+					return fn, err
+				}
+				return trace.Wrap(fn), nil
 			}
+		} else {
+			fn = trace.Wrap(fn)
+		}
+	}
+
+	for i, opts := range it.runs {
+
+		it.options = opts
 
-			fmt.Printf("waiting %8.2f\r", cio[0]/10)
+		if len(it.runs) > 1 {
+			fmt.Printf("Bench run %d/%d %s\n", i+1, len(it.runs), strings.Join(opts.attrs, " "))
 		}
 
-		if err := fn.Clean(); err != nil {
-			return err
+		// the random choices of the clients are replayed with --seed
+		fmt.Printf("Bench seed %d\n", opts.seed)
+
+		for _, typ := range it.options.types {
+
+			if it.options.saturation != "" {
+				if err := it.runSaturation(fn, typ, &ls); err != nil {
+					return err
+				}
+				if it.isInterrupted() {
+					return ErrInterrupted
+				}
+				continue
+			}
+
+			benchItem, err := it.runItem(fn, it.options, typ)
+			if err != nil {
+				return err
+			}
+
+			if err := it.datasetsSave(&ls, benchItem.datasets.Items); err != nil {
+				return err
+			}
+
+			if it.isInterrupted() {
+				return ErrInterrupted
+			}
+
+			if err := benchItem.verifyError(); err != nil {
+				return err
+			}
 		}
+	}
 
-		benchName := fmt.Sprintf("%s/%s/client-x%d",
-			it.options.dataName, benchTypeName(typ), it.options.clientNum)
+	return nil
+}
 
-		fmt.Printf("Bench %s Start at %s\n",
-			benchName, time.Now().Format("2006-01-02 15:04:05"))
+// interrupt stops the current run, which saves its datasets as partial, and
+// the bench types after it.
+func (it *KeyValueBench) interrupt() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.interrupted = true
+	if it.current != nil {
+		it.current.interrupt()
+	}
+}
 
-		if err := benchItem.run(fn); err != nil {
-			return err
+func (it *KeyValueBench) isInterrupted() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.interrupted
+}
+
+func (it *KeyValueBench) runItem(fn KeyValueBenchWorker,
+	opts *keyValueBenchOptions, typ uint64) (*keyValueBenchItem, error) {
+
+	benchItem := newkeyValueBenchItem(opts)
+	benchItem.typ = typ
+
+	it.mu.Lock()
+	it.current = benchItem
+	if it.interrupted {
+		benchItem.interrupt()
+	}
+	it.mu.Unlock()
+
+	if it.factory != nil {
+		if err := benchItem.clientsSetup(it.factory); err != nil {
+			return nil, err
 		}
+		defer benchItem.clientsTeardown()
+		fn = benchItem.workers[0]
+	}
 
-		fmt.Printf("Bench %s DONE at %s\n",
-			benchName, time.Now().Format("2006-01-02 15:04:05"))
+	if opts.verify {
+		if _, ok := fn.(KeyValueBenchValueReader); !ok {
+			return nil, errors.New("--verify requires a KeyValueBenchValueReader worker")
+		}
+	}
+
+	var cio []float64
+	for !benchItem.stopped() {
+		cio, _ = ps_cpu.Percent(3e9, false)
 
-		for _, ds := range benchItem.datasets.Items {
-			ls.Set(ds)
+		if (cio[0] / 10) < 1.0 {
+			break
 		}
 
-		if err := json.EncodeToFile(ls, it.options.dataFile, "  "); err != nil {
-			return err
+		fmt.Printf("waiting %8.2f\r", cio[0]/10)
+	}
+
+	// keep the data of previous runs for --preload_skip
+	if !opts.preloadSkip {
+		if err := fn.Clean(); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	benchName := fmt.Sprintf("%s/%s/client-x%d",
+		opts.dataName, benchTypeName(typ), opts.clientNum)
+	if opts.rate > 0 {
+		benchName += fmt.Sprintf("/rate-%d", opts.rate)
+	}
+
+	fmt.Printf("Bench %s Start at %s\n",
+		benchName, time.Now().Format("2006-01-02 15:04:05"))
+
+	if err := benchItem.run(fn); err != nil {
+		return nil, err
+	}
+	benchItem.workerAttrs = fn.Attrs()
+
+	if it.factory != nil {
+		benchItem.clientsTeardown()
+		benchItem.clientDatasetsSync()
+	}
+
+	fmt.Printf("Bench %s DONE at %s\n",
+		benchName, time.Now().Format("2006-01-02 15:04:05"))
+
+	return benchItem, nil
+}
+
+func (it *KeyValueBench) datasetsSave(ls *hcapi.DataList, items []*hcapi.DataItem) error {
+
+	for _, ds := range items {
+		ls.Set(ds)
+	}
+
+	return json.EncodeToFile(ls, it.options.dataFile, "  ")
 }
 
 /**
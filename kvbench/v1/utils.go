@@ -15,6 +15,8 @@
 package kvbench
 
 import (
+	"bytes"
+	"compress/flate"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
@@ -23,33 +25,119 @@ import (
 )
 
 var (
-	randBytesMax = 1024 * 1024
-	randWordCap  = 3000
-	randWordLen  = 7
-	randWords    = [][]byte{}
+	randBytesMax   = 1024 * 1024
+	randValueBlock = 4096
+)
+
+const (
+	randStreamSample  = 2
+	randStreamPreload = 1 << 32 // + client id
+	randStreamOps     = 2 << 32 // + client id
 )
 
 func init() {
 	mrand.Seed(time.Now().UTC().UnixNano())
-	for i := 0; i < randWordCap; i++ {
-		randWords = append(randWords, RandBytes(randWordLen))
-	}
 }
 
-func randKey(size int, seq uint64) []byte {
-	if seq == 0 {
-		return []byte(RandHexString(size))
+// randSource is a splitmix64 source of math/rand. It is cheap to create, so
+// every generator owns one, seeded from --seed and its own stream number.
+//
+// Each client, of the preload and of the run, has its own generator, which
+// picks the op, key index, value size and value of its requests. For a seed
+// the preload and the requests of a random key distribution are the same
+// in every run. The key indexes taken in order, of the writes to new keys
+// (rand-write, seq-write and the inserts of mixed and ycsb-d/e), of the
+// deletes and of the sequential distribution (seq-read, seq-scan), come
+// from a counter shared by the clients, so which client gets which key
+// depends on the order the clients get free. Which key an index maps to
+// does not depend on the seed, see keySpaceSeed.
+type randSource struct {
+	x uint64
+}
+
+func newRand(seed int64, stream uint64) *mrand.Rand {
+	return mrand.New(&randSource{
+		x: uint64(seed) ^ splitmix64(stream),
+	})
+}
+
+func (it *randSource) Seed(seed int64) {
+	it.x = uint64(seed)
+}
+
+func (it *randSource) Uint64() uint64 {
+	v := splitmix64(it.x)
+	it.x += 0x9e3779b97f4a7c15
+	return v
+}
+
+func (it *randSource) Int63() int64 {
+	return int64(it.Uint64() >> 1)
+}
+
+// randValue returns a value which compresses to about ratio of its size:
+// every randValueBlock bytes start with ratio*block random bytes, repeated
+// to fill the block, so compressors with a small window see the redundancy
+// too. A ratio of 1 makes the value incompressible.
+func randValue(r *mrand.Rand, size int, ratio float64) []byte {
+
+	if size < 1 {
+		size = 1
 	}
-	return []byte(RandHexString(size-16) + uint64ToHexString(seq))
+
+	bs := make([]byte, size)
+
+	for i := 0; i < size; i += randValueBlock {
+
+		block := bs[i:]
+		if len(block) > randValueBlock {
+			block = block[:randValueBlock]
+		}
+
+		n := int(float64(len(block))*ratio + 0.5)
+		if n < 1 {
+			n = 1
+		} else if n > len(block) {
+			n = len(block)
+		}
+
+		r.Read(block[:n])
+		for j := n; j < len(block); j += n {
+			copy(block[j:], block[:n])
+		}
+	}
+
+	return bs
 }
 
-func randValue(size int) []byte {
-	text := []byte{}
-	for i := 0; i < size; i += (randWordLen + 1) {
-		text = append(text, bytesClone(randWords[mrand.Intn(randWordCap)])...)
-		text = append(text, ' ')
+// randValueCompressRatio returns the compressed to original size ratio of
+// sample values, as a block compressing store would see it.
+func randValueCompressRatio(r *mrand.Rand, sizes keyValueSizeChooser, ratio float64) float64 {
+
+	var (
+		raw, comp int
+		buf       bytes.Buffer
+		fw, _     = flate.NewWriter(&buf, flate.BestCompression)
+	)
+
+	for i := 0; i < 1000 && raw < 4*1024*1024; i++ {
+
+		v := randValue(r, sizes.next(r), ratio)
+
+		buf.Reset()
+		fw.Reset(&buf)
+		fw.Write(v)
+		fw.Close()
+
+		raw += len(v)
+		comp += buf.Len()
+	}
+
+	if raw < 1 {
+		return 1
 	}
-	return text
+
+	return float64Round(float64(comp)/float64(raw), 2)
 }
 
 func RandBytes(size int) []byte {